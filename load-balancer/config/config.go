@@ -6,10 +6,191 @@ import (
 	"os"
 )
 
+// HealthCheckConfig describes the active health check of a server. The check
+// passes when the response status is in one of ExpectedStatus, given as
+// "204" or "200-399" (2xx by default), and the body contains Body and
+// matches BodyRegex when they are set. A "Host" entry in Headers sets the
+// request host.
+//
+// A server goes down after Fall failed checks in a row and comes back after
+// Rise passed ones, both 1 by default. It is checked every IntervalSeconds
+// while up and every UnhealthyIntervalSeconds while down, both defaulting to
+// app.health_check_seconds, plus a random JitterPercent of the interval.
+type HealthCheckConfig struct {
+	Method         string            `json:"method"`
+	ExpectedStatus []string          `json:"expected_status"`
+	Body           string            `json:"body"`
+	BodyRegex      string            `json:"body_regex"`
+	Headers        map[string]string `json:"headers"`
+	TimeoutSeconds float64           `json:"timeout_seconds"`
+
+	Rise                     int     `json:"rise"`
+	Fall                     int     `json:"fall"`
+	IntervalSeconds          float64 `json:"interval_seconds"`
+	UnhealthyIntervalSeconds float64 `json:"unhealthy_interval_seconds"`
+	JitterPercent            int     `json:"jitter_percent"`
+}
+
+// ServerConfig describes a backend. Servers with a higher Priority value are
+// backups for the ones with a lower value. Zone is the locality label
+// matched against ZoneConfig.LocalZone.
 type ServerConfig struct {
-	Url    string `json:"url"`
-	Health string `json:"health"`
-	Weight uint   `json:"weight"`
+	Url      string `json:"url"`
+	Health   string `json:"health"`
+	Weight   uint   `json:"weight"`
+	Priority int    `json:"priority"`
+	Zone     string `json:"zone"`
+
+	HealthCheck HealthCheckConfig `json:"health_check"`
+}
+
+// ZoneConfig enables locality aware routing. Servers in LocalZone get all
+// the traffic while their healthy weight share times OverprovisioningFactor
+// is at least 1; below that the remainder spills to the other zones.
+type ZoneConfig struct {
+	LocalZone              string  `json:"local_zone"`
+	OverprovisioningFactor float64 `json:"overprovisioning_factor"`
+}
+
+// CircuitBreakerConfig opens the circuit of a server, taking it out of
+// rotation, once FailureRatio of at least MinRequests requests in a window
+// of WindowSeconds failed with a 5xx response or a proxy error. After
+// OpenSeconds the circuit is half-open and lets HalfOpenRequests trial
+// requests through: it closes when they all succeed and opens again on the
+// first failure. Zero values keep the defaults.
+type CircuitBreakerConfig struct {
+	Enabled          bool    `json:"enabled"`
+	FailureRatio     float64 `json:"failure_ratio"`
+	MinRequests      int     `json:"min_requests"`
+	WindowSeconds    float64 `json:"window_seconds"`
+	OpenSeconds      float64 `json:"open_seconds"`
+	HalfOpenRequests int     `json:"half_open_requests"`
+}
+
+// FailoverConfig sets how much of a priority tier's weight must be healthy
+// for it to take all the traffic.
+type FailoverConfig struct {
+	MinHealthyPercent int `json:"min_healthy_percent"`
+}
+
+// HashConfig selects the request attribute used by hash based algorithms.
+// Key is one of "ip", "header", "cookie", "query" or "path"; Name is the
+// header, cookie or query parameter name. A LoadFactor of 1 or more caps
+// every server at LoadFactor times the average number of in-flight requests.
+// The client IP is taken from X-Forwarded-For or Forwarded only when the
+// immediate peer is in one of the TrustedProxies CIDRs.
+type HashConfig struct {
+	Key            string   `json:"key"`
+	Name           string   `json:"name"`
+	VirtualNodes   int      `json:"virtual_nodes"`
+	LoadFactor     float64  `json:"load_factor"`
+	TrustedProxies []string `json:"trusted_proxies"`
+}
+
+// MaglevConfig sets the Maglev lookup table size, which must be a prime
+// considerably larger than the number of servers.
+type MaglevConfig struct {
+	TableSize int `json:"table_size"`
+}
+
+// PeakEWMAConfig sets how fast old latency samples are forgotten.
+type PeakEWMAConfig struct {
+	DecaySeconds float64 `json:"decay_seconds"`
+}
+
+// AdminConfig enables a separate listener serving balancer statistics.
+type AdminConfig struct {
+	Port string `json:"port"`
+}
+
+// OutlierConfig ejects servers failing live traffic, between health checks.
+// A server is ejected after ConsecutiveErrors 5xx responses or proxy errors
+// in a row, or when its success rate over IntervalSeconds falls more than
+// SuccessRateStdevFactor standard deviations below the pool's mean. The
+// success rate is only compared once SuccessRateMinHosts servers had
+// SuccessRateRequestVolume requests in the interval. Each ejection of a
+// server lasts twice as long as the previous one, from BaseEjectionSeconds
+// up to MaxEjectionSeconds, and at most MaxEjectionPercent of the servers
+// are ejected at once. Zero values keep the defaults.
+type OutlierConfig struct {
+	Enabled                  bool    `json:"enabled"`
+	ConsecutiveErrors        int     `json:"consecutive_errors"`
+	IntervalSeconds          float64 `json:"interval_seconds"`
+	BaseEjectionSeconds      float64 `json:"base_ejection_seconds"`
+	MaxEjectionSeconds       float64 `json:"max_ejection_seconds"`
+	MaxEjectionPercent       int     `json:"max_ejection_percent"`
+	SuccessRateMinHosts      int     `json:"success_rate_min_hosts"`
+	SuccessRateRequestVolume int     `json:"success_rate_request_volume"`
+	SuccessRateStdevFactor   float64 `json:"success_rate_stdev_factor"`
+}
+
+// RetryConfig sends a failed request again to another server, up to
+// MaxAttempts attempts in all. Connection failures are retried, and for
+// idempotent methods also timeouts, resets and the listed Statuses; other
+// methods are only retried when the backend was never reached. Bodies up to
+// MaxBodyBytes are buffered so they can be sent again, larger ones are not
+// retried. PerTryTimeoutSeconds bounds the wait for the response headers of
+// each attempt, and attempts are spaced by a random backoff doubling from
+// BackoffSeconds up to MaxBackoffSeconds. Retries are off while MaxAttempts
+// is below 2; other zero values keep the defaults.
+//
+// Retries are limited by a budget shared by all requests: over the last
+// BudgetWindowSeconds they may add BudgetPercent of the requests plus
+// MinRetriesPerSecond a second, so a quiet balancer can still retry.
+type RetryConfig struct {
+	MaxAttempts          int     `json:"max_attempts"`
+	Statuses             []int   `json:"statuses"`
+	PerTryTimeoutSeconds float64 `json:"per_try_timeout_seconds"`
+	BackoffSeconds       float64 `json:"backoff_seconds"`
+	MaxBackoffSeconds    float64 `json:"max_backoff_seconds"`
+	MaxBodyBytes         int64   `json:"max_body_bytes"`
+	BudgetPercent        float64 `json:"budget_percent"`
+	MinRetriesPerSecond  float64 `json:"min_retries_per_second"`
+	BudgetWindowSeconds  int     `json:"budget_window_seconds"`
+}
+
+// SlowStartConfig ramps up the traffic to a server coming back up. Over
+// WindowSeconds its effective weight grows from MinWeightPercent of its
+// weight to all of it, linearly with Aggression 1 and faster at first with
+// larger values. Slow start is off while WindowSeconds is 0.
+type SlowStartConfig struct {
+	WindowSeconds    float64 `json:"window_seconds"`
+	Aggression       float64 `json:"aggression"`
+	MinWeightPercent int     `json:"min_weight_percent"`
+}
+
+// StickyConfig configures the affinity cookie pinning a client to a server.
+// The cookie is signed with Key and only carries an opaque server id.
+type StickyConfig struct {
+	Enabled    bool   `json:"enabled"`
+	CookieName string `json:"cookie_name"`
+	TTLSeconds int    `json:"ttl_seconds"`
+	Secure     bool   `json:"secure"`
+	HttpOnly   bool   `json:"http_only"`
+	SameSite   string `json:"same_site"`
+	Key        string `json:"key"`
+}
+
+// SubsetConfig limits this instance, one of Replicas balancer instances, to
+// Size of the servers. Subsetting is off while Replicas is 0; a Size of 0
+// spreads the servers over the replicas without overlap where possible.
+type SubsetConfig struct {
+	InstanceID int `json:"instance_id"`
+	Replicas   int `json:"replicas"`
+	Size       int `json:"size"`
+}
+
+// TransportConfig tunes the connections to the servers, shared by all of
+// them. Zero values keep the defaults; ResponseHeaderTimeoutSeconds 0 means
+// no timeout.
+type TransportConfig struct {
+	MaxIdleConnsPerHost          int  `json:"max_idle_conns_per_host"`
+	IdleConnTimeoutSeconds       int  `json:"idle_conn_timeout_seconds"`
+	DialTimeoutSeconds           int  `json:"dial_timeout_seconds"`
+	TLSHandshakeTimeoutSeconds   int  `json:"tls_handshake_timeout_seconds"`
+	ResponseHeaderTimeoutSeconds int  `json:"response_header_timeout_seconds"`
+	KeepAliveSeconds             int  `json:"keep_alive_seconds"`
+	DisableHTTP2                 bool `json:"disable_http2"`
 }
 
 type Config struct {
@@ -18,7 +199,20 @@ type Config struct {
 		Port               string `json:"port"`
 		HealthCheckSeconds int    `json:"health_check_seconds"`
 	} `json:"app"`
-	Servers []ServerConfig `json:"servers"`
+	Admin          AdminConfig          `json:"admin"`
+	CircuitBreaker CircuitBreakerConfig `json:"circuit_breaker"`
+	Failover       FailoverConfig       `json:"failover"`
+	Hash           HashConfig           `json:"hash"`
+	Maglev         MaglevConfig         `json:"maglev"`
+	Outlier        OutlierConfig        `json:"outlier"`
+	PeakEWMA       PeakEWMAConfig       `json:"peak_ewma"`
+	Retry          RetryConfig          `json:"retry"`
+	SlowStart      SlowStartConfig      `json:"slow_start"`
+	Sticky         StickyConfig         `json:"sticky"`
+	Subset         SubsetConfig         `json:"subset"`
+	Transport      TransportConfig      `json:"transport"`
+	Zone           ZoneConfig           `json:"zone"`
+	Servers        []ServerConfig       `json:"servers"`
 }
 
 func Load(path string) (*Config, error) {
@@ -3,23 +3,92 @@ package handlers
 import (
 	"emaiorov/load-balancer/config"
 	"fmt"
+	"log"
+	"math/rand/v2"
 	"net/http"
+	"net/http/httputil"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Server struct {
+	// Accessed atomically, kept first for 64-bit alignment on 32-bit platforms
+	InFlight int64
+	// Unix nanoseconds the slow start began, 0 when not in slow start.
+	// Accessed atomically.
+	slowStartSince int64
 	config.ServerConfig
-	IsAlive   bool
-	Counter   Counter
-	LoadScore uint
-	LoadCost  uint
+	IsAlive         bool
+	Counter         Counter
+	LoadScore       uint
+	LoadCost        uint
+	CurrentWeight   int
+	EffectiveWeight int
+	standby         bool
+	ejected         bool
+	circuitOpen     bool
 }
 
+// Handler is the component shared by all algorythms: it owns the servers and
+// their health, applies sticky sessions and priority failover, proxies the
+// request and handles errors. The choice of server is left to its Strategy.
 type Handler struct {
-	mu      sync.Mutex
-	Counter Counter
-	Servers []*Server
+	mu           sync.Mutex
+	membershipMu sync.Mutex
+	Counter      Counter
+	Servers      []*Server
+	generation   atomic.Uint64
+	sticky       *stickySessions
+	failover     *priorityFailover
+	strategy     Strategy
+	proxiesOnce  sync.Once
+	proxies      map[*Server]*httputil.ReverseProxy
+	probesOnce   sync.Once
+	probes       map[*Server]*healthProbe
+	probesErr    error
+	outliers     *outlierDetection
+	breakers     *circuitBreakers
+	slowStart    *slowStart
+	retries      *retryPolicy
+	// Transport carries the proxied requests, http.DefaultTransport if nil.
+	// Like Logger it must be set before the first request.
+	Transport http.RoundTripper
+	// Logger receives proxy and health check messages, the log package
+	// default logger if nil
+	Logger *log.Logger
+	// HealthCheckInterval is the time between checks of a server unless its
+	// config sets one, 5 seconds if 0
+	HealthCheckInterval time.Duration
+}
+
+func (h *Handler) logf(format string, v ...any) {
+	if h.Logger != nil {
+		h.Logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+// serverList returns a copy of the servers. Some strategies reorder
+// h.Servers in place, so it may only be read directly under h.mu.
+func (h *Handler) serverList() []*Server {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return slices.Clone(h.Servers)
+}
+
+// Available reports whether the server may receive traffic: it passes its
+// health checks, is not ejected by outlier detection, its circuit is closed
+// and it is not held back as a backup by priority failover.
+func (s *Server) Available() bool {
+	return s.healthy() && !s.standby
+}
+
+func (s *Server) healthy() bool {
+	return s.IsAlive && !s.ejected && !s.circuitOpen
 }
 
 type Counter struct {
@@ -52,6 +121,72 @@ func (c *Counter) NextAndWrap() bool {
 	return false
 }
 
+// SetAlive records a health check result. Every change of the live set bumps
+// the handler generation so cached lookup structures can be rebuilt.
+func (h *Handler) SetAlive(server *Server, isAlive bool) {
+	h.mu.Lock()
+
+	changed := server.IsAlive != isAlive
+	if changed {
+		server.IsAlive = isAlive
+		h.generation.Add(1)
+		h.updateTiers()
+		if isAlive {
+			h.startSlowStart(server)
+		}
+	}
+	h.mu.Unlock()
+
+	if changed {
+		h.updateMembership()
+	}
+}
+
+// updateMembership hands the available servers to the strategy. Updates are
+// serialized so the strategy always ends up with the latest live set.
+func (h *Handler) updateMembership() {
+	h.membershipMu.Lock()
+	defer h.membershipMu.Unlock()
+
+	h.mu.Lock()
+	strategy := h.strategy
+	var available []*Server
+	for _, server := range h.Servers {
+		if server.Available() {
+			available = append(available, server)
+		}
+	}
+	h.mu.Unlock()
+
+	if strategy != nil {
+		strategy.UpdateMembership(available)
+	}
+}
+
+// observeOutcome feeds the result of a proxied request, failed with a 5xx
+// response or a proxy error, to outlier detection and the circuit breakers.
+func (h *Handler) observeOutcome(server *Server, failed bool) {
+	h.observeOutlier(server, failed)
+	h.observeCircuit(server, failed)
+}
+
+// Stop ends the ejections, turns the open circuits half-open and stops their
+// timers, so nothing of the handler runs after it. Servers ejected or circuits
+// opened by requests proxied later are timed again.
+func (h *Handler) Stop() {
+	h.stopEjections()
+	h.stopOpenCircuits()
+}
+
+// secondsOr converts a setting in seconds to a duration, or returns fallback
+// when it is not set.
+func secondsOr(value float64, fallback time.Duration) time.Duration {
+	if value > 0 {
+		return time.Duration(value * float64(time.Second))
+	}
+	return fallback
+}
+
 func (s *Server) GetHealthUrl() string {
 	return s.Url + s.Health
 }
@@ -60,36 +195,109 @@ type LoadBalancer interface {
 	ServeHTTP(w http.ResponseWriter, r *http.Request)
 }
 
-/* Implement connection timeout */
-func getClient() *http.Client {
-	return &http.Client{
-		Timeout: time.Duration(3) * time.Second,
-	}
-}
-
-func HealthCheck(h *Handler, seconds int) {
-	sleepTime := time.Duration(seconds) * time.Second
-
-	for {
-		i := 0
-		count := len(h.Servers)
-		for i < count {
-			resp, err := getClient().Get(h.Servers[i].GetHealthUrl())
-			if err != nil {
-				fmt.Printf("health check error: %v", err)
-				fmt.Println("")
-			}
-			isAlive := (err == nil && resp.Status == "200 OK")
-			h.mu.Lock()
-			h.Servers[i].IsAlive = isAlive
-			h.mu.Unlock()
-			if !isAlive {
-				fmt.Printf("server %s is down\n", h.Servers[i].Url)
-				fmt.Println("")
-			}
-			i++
+// Result describes how a proxied request finished.
+type Result struct {
+	Err             error         // set when the backend could not be reached
+	TimeToFirstByte time.Duration // until the response headers arrived
+	Duration        time.Duration // until the response body was closed
+	Skipped         bool          // picked but passed over, nothing was sent
+	Canceled        bool          // the client went away, nothing is known of the backend
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	server := h.stickyServer(r)
+	if server == nil {
+		server = h.circuitTrial()
+	}
+
+	if server != nil {
+		if acquirer, ok := h.strategy.(Acquirer); ok {
+			acquirer.Acquire(server)
+		}
+	} else {
+		var err error
+		server, err = h.pick(r)
+
+		if err != nil {
+			w.WriteHeader(int(http.StatusServiceUnavailable))
+			fmt.Fprintf(w, "All servers failed on health check")
+			return
+		}
+		h.setStickyCookie(w, server)
+	}
+
+	h.forward(w, r, server)
+}
+
+// Picks before a random server is used instead, which hash based strategies
+// need as they pick the same server again
+const maxPicks = 3
+
+// pick asks the strategy for the server of a request.
+func (h *Handler) pick(r *http.Request) (*Server, error) {
+	return h.pickExcept(r, nil)
+}
+
+// pickExcept asks the strategy for a server not in excluded, passing over
+// servers in slow start in proportion to how far they are from their full
+// weight. A server passed over once stays passed over for this request, so
+// strategies picking it again do not give it more than its share.
+func (h *Handler) pickExcept(r *http.Request, excluded []*Server) (*Server, error) {
+	server, err := h.strategy.Pick(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var passedArray [maxPicks]*Server
+	passed := passedArray[:0]
+	for attempt := 1; ; attempt++ {
+		if !slices.Contains(excluded, server) && !slices.Contains(passed, server) && h.admits(server) {
+			return server, nil
+		}
+		h.strategy.Done(server, Result{Skipped: true})
+		if attempt == maxPicks {
+			break
 		}
+		passed = append(passed, server)
+		if server, err = h.strategy.Pick(r); err != nil {
+			return nil, err
+		}
+	}
 
-		time.Sleep(sleepTime)
+	// The strategy keeps picking servers that cannot be used
+	usable := func(s *Server) bool { return !slices.Contains(excluded, s) }
+	if server := h.randomServer(func(s *Server) bool { return usable(s) && h.warm(s) }); server != nil {
+		return server, nil
+	}
+	if h.slowStart != nil {
+		if server := h.randomServer(usable); server != nil {
+			return server, nil
+		}
+	}
+	return nil, fmt.Errorf("no server left to pick")
+}
+
+// randomServer picks a random available server accepted by accept, for when
+// the strategy keeps picking servers that cannot be used. The server is
+// acquired as if the strategy had picked it. It returns nil when there is
+// none.
+func (h *Handler) randomServer(accept func(*Server) bool) *Server {
+	h.mu.Lock()
+	var candidates []*Server
+	for _, server := range h.Servers {
+		if server.Available() && accept(server) {
+			candidates = append(candidates, server)
+		}
+	}
+	h.mu.Unlock()
+
+	if len(candidates) == 0 {
+		return nil
+	}
+	server := candidates[rand.IntN(len(candidates))]
+	if acquirer, ok := h.strategy.(Acquirer); ok {
+		acquirer.Acquire(server)
 	}
+	return server
 }
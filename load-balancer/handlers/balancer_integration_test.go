@@ -3,6 +3,7 @@
 package handlers
 
 import (
+	"context"
 	"emaiorov/load-balancer/config"
 	"fmt"
 	"io"
@@ -52,8 +53,13 @@ func TestHealthCheckIntegration(t *testing.T) {
 		mu: sync.Mutex{},
 	}
 
-	// Run the HealthCheck in the background.
-	go HealthCheck(h, 1)
+	// Run the health checks in the background.
+	h.HealthCheckInterval = time.Second
+	checker, err := StartHealthChecks(context.Background(), h)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer checker.Stop()
 
 	// We must wait for the goroutine to run its first loop.
 	time.Sleep(2500 * time.Millisecond)
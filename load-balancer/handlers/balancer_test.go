@@ -2,9 +2,22 @@ package handlers
 
 import (
 	"emaiorov/load-balancer/config"
+	"fmt"
 	"testing"
 )
 
+// newPoolServers returns n live servers of weight 1.
+func newPoolServers(n int) []Server {
+	servers := make([]Server, n)
+	for i := range servers {
+		servers[i] = Server{
+			ServerConfig: config.ServerConfig{Url: fmt.Sprintf("http://backend-%d", i), Weight: 1},
+			IsAlive:      true,
+		}
+	}
+	return servers
+}
+
 func TestLoad(t *testing.T) {
 
 	testCases := []struct {
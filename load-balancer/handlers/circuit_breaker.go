@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"emaiorov/load-balancer/config"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCircuitFailureRatio     = 0.5
+	defaultCircuitMinRequests      = 20
+	defaultCircuitWindow           = 10 * time.Second
+	defaultCircuitOpen             = 30 * time.Second
+	defaultCircuitHalfOpenRequests = 3
+)
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+type circuitBreakers struct {
+	failureRatio     float64
+	minRequests      int
+	window           time.Duration
+	openDuration     time.Duration
+	halfOpenRequests int
+
+	// mu is taken before h.mu when both are needed
+	mu       sync.Mutex
+	circuits map[*Server]*circuit
+	halfOpen int
+}
+
+type circuit struct {
+	state     circuitState
+	requests  int
+	failures  int
+	windowEnd time.Time
+	trials    int
+	successes int
+	timer     *time.Timer
+}
+
+// EnableCircuitBreakers gives every server a circuit breaker. An open
+// circuit takes the server out of rotation whatever its health checks say;
+// a half-open one lets a few trial requests through before closing. It must
+// be called before the first request.
+func (h *Handler) EnableCircuitBreakers(breakerConfig config.CircuitBreakerConfig) error {
+	if breakerConfig.FailureRatio < 0 || breakerConfig.FailureRatio > 1 {
+		return fmt.Errorf("failure ratio must be between 0 and 1, got %v", breakerConfig.FailureRatio)
+	}
+	if breakerConfig.MinRequests < 0 || breakerConfig.WindowSeconds < 0 ||
+		breakerConfig.OpenSeconds < 0 || breakerConfig.HalfOpenRequests < 0 {
+		return fmt.Errorf("circuit breaker settings must not be negative")
+	}
+
+	breakers := &circuitBreakers{
+		failureRatio:     breakerConfig.FailureRatio,
+		minRequests:      breakerConfig.MinRequests,
+		window:           secondsOr(breakerConfig.WindowSeconds, defaultCircuitWindow),
+		openDuration:     secondsOr(breakerConfig.OpenSeconds, defaultCircuitOpen),
+		halfOpenRequests: breakerConfig.HalfOpenRequests,
+	}
+	if breakers.failureRatio == 0 {
+		breakers.failureRatio = defaultCircuitFailureRatio
+	}
+	if breakers.minRequests == 0 {
+		breakers.minRequests = defaultCircuitMinRequests
+	}
+	if breakers.halfOpenRequests == 0 {
+		breakers.halfOpenRequests = defaultCircuitHalfOpenRequests
+	}
+
+	servers := h.serverList()
+	breakers.circuits = make(map[*Server]*circuit, len(servers))
+	for _, server := range servers {
+		breakers.circuits[server] = &circuit{windowEnd: time.Now().Add(breakers.window)}
+	}
+
+	h.mu.Lock()
+	h.breakers = breakers
+	h.mu.Unlock()
+
+	return nil
+}
+
+// circuitTrial returns a server with a half-open circuit still taking trial
+// requests, or nil. Trial requests go around the strategy, which only sees
+// servers with closed circuits.
+func (h *Handler) circuitTrial() *Server {
+	b := h.breakers
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.halfOpen == 0 {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, server := range h.Servers {
+		c := b.circuits[server]
+		if c.state == circuitHalfOpen && c.trials < b.halfOpenRequests && server.IsAlive && !server.ejected {
+			c.trials++
+			return server
+		}
+	}
+	return nil
+}
+
+// releaseCircuitTrial gives back the trial taken by a request to server whose
+// client went away, so its circuit keeps taking trial requests.
+func (h *Handler) releaseCircuitTrial(server *Server) {
+	b := h.breakers
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if c := b.circuits[server]; c != nil && c.state == circuitHalfOpen && c.trials > 0 {
+		c.trials--
+	}
+}
+
+// observeCircuit records whether a request proxied to server failed, with a
+// 5xx response or a proxy error, and moves its circuit accordingly.
+func (h *Handler) observeCircuit(server *Server, failed bool) {
+	b := h.breakers
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	c := b.circuits[server]
+	if c == nil {
+		b.mu.Unlock()
+		return
+	}
+
+	previous := c.state
+	switch c.state {
+	case circuitClosed:
+		now := time.Now()
+		if now.After(c.windowEnd) {
+			c.requests, c.failures = 0, 0
+			c.windowEnd = now.Add(b.window)
+		}
+		c.requests++
+		if failed {
+			c.failures++
+		}
+		if c.requests >= b.minRequests && float64(c.failures) >= b.failureRatio*float64(c.requests) {
+			h.openCircuit(server, c)
+		}
+	case circuitHalfOpen:
+		if failed {
+			h.openCircuit(server, c)
+			break
+		}
+		c.successes++
+		if c.successes >= b.halfOpenRequests {
+			h.closeCircuit(server, c)
+		}
+	}
+	state := c.state
+	b.mu.Unlock()
+
+	if state != previous {
+		if previous == circuitClosed || state == circuitClosed {
+			h.updateMembership()
+		}
+		h.logf("circuit of server %s is %s", server.Url, state)
+	}
+}
+
+// openCircuit takes server out of rotation until its circuit turns
+// half-open. Callers hold b.mu.
+func (h *Handler) openCircuit(server *Server, c *circuit) {
+	b := h.breakers
+	if c.state == circuitHalfOpen {
+		b.halfOpen--
+	}
+	c.state = circuitOpen
+
+	h.mu.Lock()
+	if !server.circuitOpen {
+		server.circuitOpen = true
+		h.generation.Add(1)
+		h.updateTiers()
+	}
+	h.mu.Unlock()
+
+	c.timer = time.AfterFunc(b.openDuration, func() { h.halfOpenCircuit(server) })
+}
+
+// stopOpenCircuits turns the open circuits half-open right away, stopping the
+// timers that would have done it later.
+func (h *Handler) stopOpenCircuits() {
+	b := h.breakers
+	if b == nil {
+		return
+	}
+
+	var open []*Server
+	b.mu.Lock()
+	for server, c := range b.circuits {
+		if c.timer != nil && c.timer.Stop() {
+			open = append(open, server)
+		}
+		c.timer = nil
+	}
+	b.mu.Unlock()
+
+	for _, server := range open {
+		h.halfOpenCircuit(server)
+	}
+}
+
+func (h *Handler) halfOpenCircuit(server *Server) {
+	b := h.breakers
+
+	b.mu.Lock()
+	c := b.circuits[server]
+	if c.state != circuitOpen {
+		b.mu.Unlock()
+		return
+	}
+	c.state = circuitHalfOpen
+	c.trials, c.successes = 0, 0
+	b.halfOpen++
+	b.mu.Unlock()
+
+	h.logf("circuit of server %s is %s", server.Url, circuitHalfOpen)
+}
+
+// closeCircuit puts server back into rotation. Callers hold b.mu.
+func (h *Handler) closeCircuit(server *Server, c *circuit) {
+	b := h.breakers
+	b.halfOpen--
+	c.state = circuitClosed
+	c.requests, c.failures = 0, 0
+	c.windowEnd = time.Now().Add(b.window)
+
+	h.mu.Lock()
+	server.circuitOpen = false
+	h.generation.Add(1)
+	h.updateTiers()
+	h.mu.Unlock()
+}
+
+// CircuitStates returns the state of the circuit of each server by url:
+// "closed", "open" or "half-open". It is nil without circuit breakers.
+func (h *Handler) CircuitStates() map[string]string {
+	b := h.breakers
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	states := make(map[string]string, len(b.circuits))
+	for server, c := range b.circuits {
+		states[server.Url] = c.state.String()
+	}
+	return states
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"emaiorov/load-balancer/config"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newCircuitTestHandler(t *testing.T, failing *atomic.Bool) *Handler {
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("hang") {
+			<-r.Context().Done()
+			return
+		}
+		if failing.Load() {
+			http.Error(w, "flaky", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("flaky"))
+	}))
+	t.Cleanup(flaky.Close)
+	backend := newNamedBackend(t, "backend")
+
+	handler, err := NewHandler("RoundRobin", []Server{
+		{ServerConfig: config.ServerConfig{Url: flaky.URL}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: backend.URL}, IsAlive: true},
+	}, &config.Config{CircuitBreaker: config.CircuitBreakerConfig{
+		Enabled:          true,
+		FailureRatio:     0.5,
+		MinRequests:      4,
+		OpenSeconds:      0.05,
+		HalfOpenRequests: 2,
+	}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	return handler
+}
+
+// countFlaky sends n requests and counts the ones reaching the flaky server.
+func countFlaky(handler *Handler, n int) int {
+	count := 0
+	for range n {
+		if body, _ := sendWithCookie(handler, nil); body != "backend" {
+			count++
+		}
+	}
+	return count
+}
+
+func TestCircuitBreakerOpensAndCloses(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	handler := newCircuitTestHandler(t, &failing)
+	flakyUrl := handler.Servers[0].Url
+
+	if count := countFlaky(handler, 12); count != 4 {
+		t.Errorf("Wrong number of requests to the flaky server: got %d, want 4", count)
+	}
+	if state := handler.CircuitStates()[flakyUrl]; state != "open" {
+		t.Errorf("Wrong circuit state: got %s, want open", state)
+	}
+	if !handler.Servers[0].IsAlive {
+		t.Errorf("Open circuit changed the health of the server")
+	}
+
+	time.Sleep(80 * time.Millisecond)
+	if state := handler.CircuitStates()[flakyUrl]; state != "half-open" {
+		t.Errorf("Wrong circuit state: got %s, want half-open", state)
+	}
+
+	failing.Store(false)
+	if count := countFlaky(handler, 2); count != 2 {
+		t.Errorf("Wrong number of trial requests: got %d, want 2", count)
+	}
+	if state := handler.CircuitStates()[flakyUrl]; state != "closed" {
+		t.Errorf("Wrong circuit state: got %s, want closed", state)
+	}
+	if count := countFlaky(handler, 4); count != 2 {
+		t.Errorf("Wrong number of requests after closing: got %d, want 2", count)
+	}
+}
+
+func TestCircuitBreakerFailedTrialReopens(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	handler := newCircuitTestHandler(t, &failing)
+	flakyUrl := handler.Servers[0].Url
+
+	countFlaky(handler, 8)
+	time.Sleep(80 * time.Millisecond)
+
+	if count := countFlaky(handler, 4); count != 1 {
+		t.Errorf("Wrong number of trial requests: got %d, want 1", count)
+	}
+	if state := handler.CircuitStates()[flakyUrl]; state != "open" {
+		t.Errorf("Wrong circuit state: got %s, want open", state)
+	}
+}
+
+func TestCircuitBreakerStopHalfOpensCircuits(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	handler := newCircuitTestHandler(t, &failing)
+	flakyUrl := handler.Servers[0].Url
+	var logs bytes.Buffer
+	handler.Logger = log.New(&logs, "", 0)
+
+	countFlaky(handler, 8)
+	if state := handler.CircuitStates()[flakyUrl]; state != "open" {
+		t.Fatalf("Wrong circuit state: got %s, want open", state)
+	}
+
+	handler.Stop()
+	if state := handler.CircuitStates()[flakyUrl]; state != "half-open" {
+		t.Errorf("Wrong circuit state after Stop: got %s, want half-open", state)
+	}
+
+	// The open timer does not fire once stopped
+	logs.Reset()
+	time.Sleep(80 * time.Millisecond)
+	if logs.Len() != 0 {
+		t.Errorf("Circuit timer fired after Stop: %q", logs.String())
+	}
+}
+
+// sendCanceled sends n requests to handler that the flaky server holds until
+// the client gives up.
+func sendCanceled(handler *Handler, n int) {
+	for range n {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		req := httptest.NewRequest(http.MethodGet, "/?hang", nil).WithContext(ctx)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		cancel()
+	}
+}
+
+func TestCircuitBreakerIgnoresClientCancellation(t *testing.T) {
+	var failing atomic.Bool
+	handler := newCircuitTestHandler(t, &failing)
+	handler.Logger = log.New(io.Discard, "", 0)
+	flakyUrl := handler.Servers[0].Url
+
+	sendCanceled(handler, 10)
+	if state := handler.CircuitStates()[flakyUrl]; state != "closed" {
+		t.Errorf("Wrong circuit state after client timeouts: got %s, want closed", state)
+	}
+
+	failing.Store(true)
+	countFlaky(handler, 8)
+	time.Sleep(80 * time.Millisecond)
+
+	// Canceled trials give their place back to the next ones
+	sendCanceled(handler, 2)
+	failing.Store(false)
+	if count := countFlaky(handler, 2); count != 2 {
+		t.Errorf("Wrong number of trial requests: got %d, want 2", count)
+	}
+	if state := handler.CircuitStates()[flakyUrl]; state != "closed" {
+		t.Errorf("Wrong circuit state: got %s, want closed", state)
+	}
+}
+
+func TestEnableCircuitBreakersErrors(t *testing.T) {
+	for _, breakerConfig := range []config.CircuitBreakerConfig{
+		{FailureRatio: 1.5},
+		{MinRequests: -1},
+		{OpenSeconds: -1},
+	} {
+		h := &Handler{}
+		if err := h.EnableCircuitBreakers(breakerConfig); err == nil {
+			t.Errorf("Expected error for %+v", breakerConfig)
+		}
+	}
+
+	if states := (&Handler{}).CircuitStates(); states != nil {
+		t.Errorf("Wrong states without circuit breakers: got %v, want nil", states)
+	}
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"cmp"
+	"crypto/md5"
+	"emaiorov/load-balancer/config"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync/atomic"
+)
+
+// Number of ring points per unit of weight, as in libketama.
+const defaultVirtualNodes = 160
+
+type ringPoint struct {
+	hash   uint32
+	server *Server
+}
+
+// ConsistentHashHandler maps request keys onto a ketama style hash ring.
+// Dead servers stay on the ring and are skipped while walking it, so only
+// the keys owned by a failed server move to its neighbours.
+//
+// With a LoadFactor set, the handler implements consistent hashing with
+// bounded loads: a server already holding LoadFactor times the average
+// in-flight load is passed over and the walk continues to the next one.
+// Requests in flight are counted per server in InFlight.
+type ConsistentHashHandler struct {
+	Handler
+	ring       []ringPoint
+	key        KeyFunc
+	LoadFactor float64
+}
+
+func NewConsistentHashHandler(servers []Server, hashConfig config.HashConfig) (*ConsistentHashHandler, error) {
+	return newConsistentHashHandler(serverPointers(servers), hashConfig)
+}
+
+func newConsistentHashHandler(serversPtrs []*Server, hashConfig config.HashConfig) (*ConsistentHashHandler, error) {
+	key, err := NewKeyFunc(hashConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if hashConfig.LoadFactor != 0 && hashConfig.LoadFactor < 1 {
+		return nil, fmt.Errorf("hash load factor must be at least 1, got %v", hashConfig.LoadFactor)
+	}
+
+	virtualNodes := hashConfig.VirtualNodes
+	if virtualNodes <= 0 {
+		virtualNodes = defaultVirtualNodes
+	}
+
+	var ring []ringPoint
+
+	for _, server := range serversPtrs {
+		if server.Weight == 0 {
+			server.Weight = 1
+		}
+		server.InFlight = 0
+		points := virtualNodes * int(server.Weight)
+		// Every md5 digest yields four ring points
+		for j := 0; j*4 < points; j++ {
+			digest := md5.Sum([]byte(server.Url + "-" + strconv.Itoa(j)))
+			for k := 0; k < 4 && j*4+k < points; k++ {
+				ring = append(ring, ringPoint{
+					hash:   binary.LittleEndian.Uint32(digest[k*4:]),
+					server: server,
+				})
+			}
+		}
+	}
+
+	slices.SortFunc(ring, func(a, b ringPoint) int {
+		return cmp.Compare(a.hash, b.hash)
+	})
+
+	handler := &ConsistentHashHandler{
+		Handler: Handler{
+			Servers: serversPtrs,
+		},
+		ring:       ring,
+		key:        key,
+		LoadFactor: hashConfig.LoadFactor,
+	}
+	handler.strategy = handler
+
+	return handler, nil
+}
+
+func hashKey(key string) uint32 {
+	digest := md5.Sum([]byte(key))
+	return binary.LittleEndian.Uint32(digest[:4])
+}
+
+// GetServer returns the first live server clockwise from the request key
+// that is within its load bound, and counts the request against it.
+func (h *ConsistentHashHandler) GetServer(r *http.Request) (*Server, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hash := hashKey(h.key(r))
+	start, _ := slices.BinarySearchFunc(h.ring, hash, func(p ringPoint, hash uint32) int {
+		return cmp.Compare(p.hash, hash)
+	})
+
+	// Without a load factor every server is under its bound
+	var inFlight int64
+	var totalWeight uint
+	if h.LoadFactor != 0 {
+		for _, server := range h.Servers {
+			if server.Available() {
+				inFlight += atomic.LoadInt64(&server.InFlight)
+				totalWeight += server.Weight
+			}
+		}
+	}
+
+	var fallback *Server
+	for i := range h.ring {
+		server := h.ring[(start+i)%len(h.ring)].server
+		if !server.Available() {
+			continue
+		}
+		if fallback == nil {
+			fallback = server
+		}
+		if h.underLoadBound(server, inFlight, totalWeight) {
+			atomic.AddInt64(&server.InFlight, 1)
+			return server, nil
+		}
+	}
+
+	// Bounds always leave room on some server, this only guards rounding
+	if fallback != nil {
+		atomic.AddInt64(&fallback.InFlight, 1)
+		return fallback, nil
+	}
+
+	return &Server{}, fmt.Errorf("no active destinations")
+}
+
+// underLoadBound reports whether the server may take one more request when
+// each server is capped at ceil(LoadFactor * (inFlight+1) * weight share).
+func (h *ConsistentHashHandler) underLoadBound(server *Server, inFlight int64, totalWeight uint) bool {
+	if h.LoadFactor == 0 {
+		return true
+	}
+	share := float64(server.Weight) / float64(totalWeight)
+	bound := math.Ceil(h.LoadFactor * float64(inFlight+1) * share)
+	return float64(atomic.LoadInt64(&server.InFlight)) < bound
+}
+
+func (h *ConsistentHashHandler) Pick(r *http.Request) (*Server, error) {
+	return h.GetServer(r)
+}
+
+// Acquire counts a request routed to server without Pick, see Acquirer.
+func (h *ConsistentHashHandler) Acquire(server *Server) {
+	atomic.AddInt64(&server.InFlight, 1)
+}
+
+func (h *ConsistentHashHandler) Done(server *Server, result Result) {
+	atomic.AddInt64(&server.InFlight, -1)
+}
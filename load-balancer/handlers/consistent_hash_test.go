@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"emaiorov/load-balancer/config"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestNewKeyFunc(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/cart/items?user=42", nil)
+	req.RemoteAddr = "10.1.2.3:5555"
+	req.Header.Set("X-User", "alice")
+	req.AddCookie(&http.Cookie{Name: "session", Value: "s-1"})
+
+	testCases := []struct {
+		name        string
+		hashConfig  config.HashConfig
+		expectedKey string
+	}{
+		{name: "DefaultIsClientIp", hashConfig: config.HashConfig{}, expectedKey: "10.1.2.3"},
+		{name: "Path", hashConfig: config.HashConfig{Key: "path"}, expectedKey: "/cart/items"},
+		{name: "Header", hashConfig: config.HashConfig{Key: "header", Name: "X-User"}, expectedKey: "alice"},
+		{name: "Cookie", hashConfig: config.HashConfig{Key: "cookie", Name: "session"}, expectedKey: "s-1"},
+		{name: "Query", hashConfig: config.HashConfig{Key: "query", Name: "user"}, expectedKey: "42"},
+		{name: "MissingHeaderFallsBackToIp", hashConfig: config.HashConfig{Key: "header", Name: "X-Other"}, expectedKey: "10.1.2.3"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			key, err := NewKeyFunc(tc.hashConfig)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if got := key(req); got != tc.expectedKey {
+				t.Errorf("Wrong key: got %s, want %s", got, tc.expectedKey)
+			}
+		})
+	}
+}
+
+func TestNewKeyFuncErrors(t *testing.T) {
+	for _, hashConfig := range []config.HashConfig{
+		{Key: "header"},
+		{Key: "unknown", Name: "x"},
+	} {
+		if _, err := NewKeyFunc(hashConfig); err == nil {
+			t.Errorf("Expected error for hash config %+v", hashConfig)
+		}
+	}
+}
+
+func hashRequest(key string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Key", key)
+	return req
+}
+
+func TestConsistentHashWeightedDistribution(t *testing.T) {
+	servers := []Server{
+		{ServerConfig: config.ServerConfig{Url: "http://s1", Weight: 1}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: "http://s2", Weight: 3}, IsAlive: true},
+	}
+	chHandler, err := NewConsistentHashHandler(servers, config.HashConfig{Key: "header", Name: "X-Key"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(chHandler.ring) != 4*defaultVirtualNodes {
+		t.Errorf("Wrong ring size: got %d, want %d", len(chHandler.ring), 4*defaultVirtualNodes)
+	}
+
+	counts := map[string]int{}
+	for i := range 10000 {
+		server, err := chHandler.GetServer(hashRequest(fmt.Sprintf("key-%d", i)))
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		counts[server.Url]++
+	}
+
+	// Expect roughly a 1:3 split
+	if counts["http://s1"] < 1500 || counts["http://s1"] > 3500 {
+		t.Errorf("Unexpected distribution: %v", counts)
+	}
+}
+
+func TestConsistentHashOnlyFailedServerKeysMove(t *testing.T) {
+	servers := []Server{
+		{ServerConfig: config.ServerConfig{Url: "http://s1", Weight: 1}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: "http://s2", Weight: 1}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: "http://s3", Weight: 1}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: "http://s4", Weight: 1}, IsAlive: true},
+	}
+	chHandler, err := NewConsistentHashHandler(servers, config.HashConfig{Key: "header", Name: "X-Key"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	before := map[string]string{}
+	for i := range 2000 {
+		key := fmt.Sprintf("key-%d", i)
+		server, _ := chHandler.GetServer(hashRequest(key))
+		before[key] = server.Url
+	}
+
+	chHandler.Servers[1].IsAlive = false
+
+	for key, url := range before {
+		server, err := chHandler.GetServer(hashRequest(key))
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if url != "http://s2" && server.Url != url {
+			t.Errorf("Key %s moved from %s to %s", key, url, server.Url)
+		}
+		if server.Url == "http://s2" {
+			t.Errorf("Key %s routed to dead server", key)
+		}
+	}
+}
+
+func TestConsistentHashBoundedLoads(t *testing.T) {
+	testCases := []struct {
+		name            string
+		loadFactor      float64
+		expectedMaxLoad int64
+	}{
+		{name: "CaseUnboundedSendsHotKeyToOneServer", loadFactor: 0, expectedMaxLoad: 40},
+		{name: "CaseBoundedSpreadsHotKey", loadFactor: 1.25, expectedMaxLoad: 13},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			servers := []Server{
+				{ServerConfig: config.ServerConfig{Url: "http://s1", Weight: 1}, IsAlive: true},
+				{ServerConfig: config.ServerConfig{Url: "http://s2", Weight: 1}, IsAlive: true},
+				{ServerConfig: config.ServerConfig{Url: "http://s3", Weight: 1}, IsAlive: true},
+				{ServerConfig: config.ServerConfig{Url: "http://s4", Weight: 1}, IsAlive: true},
+			}
+			chHandler, err := NewConsistentHashHandler(servers, config.HashConfig{
+				Key:        "header",
+				Name:       "X-Key",
+				LoadFactor: tc.loadFactor,
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+
+			// Keep every request in flight
+			for range 40 {
+				if _, err := chHandler.GetServer(hashRequest("viral")); err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+			}
+
+			var maxLoad int64
+			for _, server := range chHandler.Servers {
+				maxLoad = max(maxLoad, server.InFlight)
+			}
+			if maxLoad != tc.expectedMaxLoad {
+				t.Errorf("Wrong max load: got %d, want %d", maxLoad, tc.expectedMaxLoad)
+			}
+		})
+	}
+}
+
+func TestConsistentHashBoundedLoadsReleasesLoad(t *testing.T) {
+	servers := []Server{
+		{ServerConfig: config.ServerConfig{Url: "http://s1", Weight: 1}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: "http://s2", Weight: 1}, IsAlive: true},
+	}
+	chHandler, _ := NewConsistentHashHandler(servers, config.HashConfig{
+		Key:        "header",
+		Name:       "X-Key",
+		LoadFactor: 1,
+	})
+
+	first, _ := chHandler.GetServer(hashRequest("viral"))
+	chHandler.Done(first, Result{})
+	second, _ := chHandler.GetServer(hashRequest("viral"))
+
+	if first != second {
+		t.Errorf("Key did not return to its owner after load was released: got %s, want %s", second.Url, first.Url)
+	}
+}
+
+func TestConsistentHashLargeWeightedPool(t *testing.T) {
+	for _, loadFactor := range []float64{0, 1.25} {
+		var servers []Server
+		for i := range 32 {
+			servers = append(servers, Server{
+				ServerConfig: config.ServerConfig{Url: fmt.Sprintf("http://s%d", i), Weight: 4},
+				IsAlive:      true,
+			})
+		}
+		chHandler, err := NewConsistentHashHandler(servers, config.HashConfig{
+			Key:          "header",
+			Name:         "X-Key",
+			VirtualNodes: 10,
+			LoadFactor:   loadFactor,
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+
+		for i := range 100 {
+			if _, err := chHandler.GetServer(hashRequest(strconv.Itoa(i))); err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+		}
+
+		var inFlight int64
+		for _, server := range chHandler.Servers {
+			inFlight += server.InFlight
+		}
+		if inFlight != 100 {
+			t.Errorf("Wrong requests in flight with load factor %v: got %d, want 100", loadFactor, inFlight)
+		}
+	}
+}
+
+func TestConsistentHashRejectsLoadFactorBelowOne(t *testing.T) {
+	_, err := NewConsistentHashHandler(nil, config.HashConfig{LoadFactor: 0.5})
+	if err == nil {
+		t.Errorf("Expected error for load factor below 1")
+	}
+}
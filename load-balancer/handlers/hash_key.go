@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"emaiorov/load-balancer/config"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// KeyFunc extracts the value hash based handlers route on.
+type KeyFunc func(r *http.Request) string
+
+// NewKeyFunc builds a KeyFunc from the hash configuration. Requests missing
+// the configured header, cookie or query parameter fall back to the client IP.
+func NewKeyFunc(hashConfig config.HashConfig) (KeyFunc, error) {
+	name := hashConfig.Name
+
+	clientIP, err := NewClientIPFunc(hashConfig.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	switch hashConfig.Key {
+	case "", "ip":
+		return clientIP, nil
+	case "path":
+		return func(r *http.Request) string {
+			return r.URL.Path
+		}, nil
+	}
+
+	if name == "" {
+		return nil, fmt.Errorf("hash key '%s' requires a name", hashConfig.Key)
+	}
+
+	switch hashConfig.Key {
+	case "header":
+		return func(r *http.Request) string {
+			if value := r.Header.Get(name); value != "" {
+				return value
+			}
+			return clientIP(r)
+		}, nil
+	case "cookie":
+		return func(r *http.Request) string {
+			if cookie, err := r.Cookie(name); err == nil && cookie.Value != "" {
+				return cookie.Value
+			}
+			return clientIP(r)
+		}, nil
+	case "query":
+		return func(r *http.Request) string {
+			if value := r.URL.Query().Get(name); value != "" {
+				return value
+			}
+			return clientIP(r)
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown hash key '%s'", hashConfig.Key)
+}
+
+// NewClientIPFunc returns a KeyFunc resolving the client address. It is the
+// peer address from r.RemoteAddr unless the peer is a trusted proxy; then
+// the Forwarded (or else X-Forwarded-For) chain is walked from the right and
+// the first address that is not a trusted proxy is the client. Entries may
+// be CIDRs or single addresses.
+func NewClientIPFunc(trustedProxies []string) (KeyFunc, error) {
+	var trusted []netip.Prefix
+	for _, proxy := range trustedProxies {
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(proxy)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid trusted proxy '%s': %w", proxy, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		trusted = append(trusted, prefix.Masked())
+	}
+
+	isTrusted := func(address string) bool {
+		addr, err := netip.ParseAddr(address)
+		if err != nil {
+			return false
+		}
+		addr = addr.Unmap()
+		for _, prefix := range trusted {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(r *http.Request) string {
+		peer := remoteIP(r)
+		if len(trusted) == 0 || !isTrusted(peer) {
+			return peer
+		}
+
+		chain := forwardedFor(r.Header)
+		if len(chain) == 0 {
+			chain = xForwardedFor(r.Header)
+		}
+
+		for i := len(chain) - 1; i >= 0; i-- {
+			if !isTrusted(chain[i]) {
+				return chain[i]
+			}
+		}
+		if len(chain) > 0 {
+			return chain[0]
+		}
+		return peer
+	}, nil
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func xForwardedFor(header http.Header) []string {
+	var chain []string
+	for _, value := range header.Values("X-Forwarded-For") {
+		for _, address := range strings.Split(value, ",") {
+			if address = strings.TrimSpace(address); address != "" {
+				chain = append(chain, address)
+			}
+		}
+	}
+	return chain
+}
+
+// forwardedFor returns the for= parameters of RFC 7239 Forwarded headers
+// with quotes, brackets and ports removed.
+func forwardedFor(header http.Header) []string {
+	var chain []string
+	for _, value := range header.Values("Forwarded") {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, node, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if !found || !strings.EqualFold(key, "for") {
+					continue
+				}
+				chain = append(chain, forwardedNode(strings.Trim(node, `"`)))
+			}
+		}
+	}
+	return chain
+}
+
+func forwardedNode(node string) string {
+	if strings.HasPrefix(node, "[") {
+		if end := strings.Index(node, "]"); end > 0 {
+			return node[1:end]
+		}
+	}
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+	return node
+}
@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"context"
+	"emaiorov/load-balancer/config"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHealthCheckInterval = 5 * time.Second
+	defaultHealthCheckTimeout  = 3 * time.Second
+	// Health responses are read up to this size, to match the body and to
+	// let the connection be reused
+	maxHealthCheckBody = 64 << 10
+)
+
+type statusRange struct {
+	from int
+	to   int
+}
+
+// healthProbe is the compiled health check of one server and the state of
+// its thresholds. The state is only touched by the goroutine checking it.
+type healthProbe struct {
+	method    string
+	statuses  []statusRange
+	body      string
+	bodyRegex *regexp.Regexp
+	headers   http.Header
+	host      string
+	client    *http.Client
+
+	rise              int
+	fall              int
+	interval          time.Duration // 0 for the handler interval
+	unhealthyInterval time.Duration // 0 for interval
+	jitter            float64
+	successes         int
+	failures          int
+}
+
+func newHealthProbe(healthConfig config.HealthCheckConfig) (*healthProbe, error) {
+	probe := &healthProbe{
+		method:  strings.ToUpper(healthConfig.Method),
+		body:    healthConfig.Body,
+		headers: make(http.Header),
+		client: &http.Client{
+			Timeout: defaultHealthCheckTimeout,
+			// A redirect status is the answer, do not follow it
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+	if probe.method == "" {
+		probe.method = http.MethodGet
+	}
+
+	if healthConfig.Rise < 0 || healthConfig.Fall < 0 {
+		return nil, fmt.Errorf("health check rise and fall must not be negative, got %d and %d", healthConfig.Rise, healthConfig.Fall)
+	}
+	probe.rise = max(healthConfig.Rise, 1)
+	probe.fall = max(healthConfig.Fall, 1)
+
+	if healthConfig.IntervalSeconds < 0 || healthConfig.UnhealthyIntervalSeconds < 0 {
+		return nil, fmt.Errorf("health check intervals must not be negative")
+	}
+	probe.interval = secondsOr(healthConfig.IntervalSeconds, 0)
+	probe.unhealthyInterval = secondsOr(healthConfig.UnhealthyIntervalSeconds, 0)
+
+	if healthConfig.JitterPercent < 0 || healthConfig.JitterPercent > 100 {
+		return nil, fmt.Errorf("health check jitter percent must be between 0 and 100, got %d", healthConfig.JitterPercent)
+	}
+	probe.jitter = float64(healthConfig.JitterPercent) / 100
+
+	if healthConfig.TimeoutSeconds < 0 {
+		return nil, fmt.Errorf("health check timeout must not be negative, got %v", healthConfig.TimeoutSeconds)
+	}
+	probe.client.Timeout = secondsOr(healthConfig.TimeoutSeconds, probe.client.Timeout)
+
+	for _, status := range healthConfig.ExpectedStatus {
+		statuses, err := parseStatusRange(status)
+		if err != nil {
+			return nil, err
+		}
+		probe.statuses = append(probe.statuses, statuses)
+	}
+	if len(probe.statuses) == 0 {
+		probe.statuses = []statusRange{{from: 200, to: 299}}
+	}
+
+	if healthConfig.BodyRegex != "" {
+		bodyRegex, err := regexp.Compile(healthConfig.BodyRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid health check body regex: %w", err)
+		}
+		probe.bodyRegex = bodyRegex
+	}
+
+	for name, value := range healthConfig.Headers {
+		if strings.EqualFold(name, "Host") {
+			probe.host = value
+			continue
+		}
+		probe.headers.Set(name, value)
+	}
+
+	return probe, nil
+}
+
+// parseStatusRange parses "204" or "200-399".
+func parseStatusRange(status string) (statusRange, error) {
+	from, to, isRange := strings.Cut(status, "-")
+	if !isRange {
+		to = from
+	}
+
+	fromCode, errFrom := strconv.Atoi(strings.TrimSpace(from))
+	toCode, errTo := strconv.Atoi(strings.TrimSpace(to))
+	if errFrom != nil || errTo != nil || fromCode < 100 || toCode > 599 || fromCode > toCode {
+		return statusRange{}, fmt.Errorf("invalid expected health check status '%s'", status)
+	}
+	return statusRange{from: fromCode, to: toCode}, nil
+}
+
+// check probes url and returns why the server is unhealthy, or nil.
+func (p *healthProbe) check(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, p.method, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header = p.headers.Clone()
+	if p.host != "" {
+		req.Host = p.host
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxHealthCheckBody))
+	if err != nil {
+		return err
+	}
+
+	if !p.expectedStatus(resp.StatusCode) {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if p.body != "" && !strings.Contains(string(body), p.body) {
+		return fmt.Errorf("body does not contain '%s'", p.body)
+	}
+	if p.bodyRegex != nil && !p.bodyRegex.Match(body) {
+		return fmt.Errorf("body does not match '%s'", p.bodyRegex)
+	}
+	return nil
+}
+
+func (p *healthProbe) expectedStatus(code int) bool {
+	for _, status := range p.statuses {
+		if code >= status.from && code <= status.to {
+			return true
+		}
+	}
+	return false
+}
+
+// record counts a check result and reports whether the server, currently
+// isAlive, crossed the rise or fall threshold.
+func (p *healthProbe) record(err error, isAlive bool) bool {
+	if err == nil {
+		p.successes++
+		p.failures = 0
+		return !isAlive && p.successes >= p.rise
+	}
+	p.failures++
+	p.successes = 0
+	return isAlive && p.failures >= p.fall
+}
+
+// wait returns the time until the next check of a server that is isAlive,
+// with jitter spreading the checks of several balancers apart.
+func (p *healthProbe) wait(isAlive bool, defaultInterval time.Duration) time.Duration {
+	interval := p.interval
+	if interval == 0 {
+		interval = defaultInterval
+	}
+	if !isAlive && p.unhealthyInterval != 0 {
+		interval = p.unhealthyInterval
+	}
+	return interval + time.Duration(rand.Float64()*p.jitter*float64(interval))
+}
+
+// prepareHealthChecks compiles the health check of every server once.
+func (h *Handler) prepareHealthChecks() error {
+	h.probesOnce.Do(func() {
+		servers := h.serverList()
+		h.probes = make(map[*Server]*healthProbe, len(servers))
+		for _, server := range servers {
+			probe, err := newHealthProbe(server.HealthCheck)
+			if err != nil {
+				h.probesErr = fmt.Errorf("server %s: %w", server.Url, err)
+				return
+			}
+			h.probes[server] = probe
+		}
+	})
+	return h.probesErr
+}
+
+// HealthChecker runs the health checks of a Handler, each server in its own
+// goroutine, so a hung server does not hold up the checks of the others.
+type HealthChecker struct {
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// StartHealthChecks checks the servers of h in the background until ctx is
+// done or Stop is called.
+func StartHealthChecks(ctx context.Context, h *Handler) (*HealthChecker, error) {
+	if err := h.prepareHealthChecks(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	checker := &HealthChecker{cancel: cancel}
+
+	for _, server := range h.serverList() {
+		checker.wg.Add(1)
+		go checker.run(ctx, h, server, h.probes[server])
+	}
+
+	return checker, nil
+}
+
+// Stop cancels the checks, including the ones in flight, and waits for them
+// to return.
+func (c *HealthChecker) Stop() {
+	c.cancel()
+	c.wg.Wait()
+}
+
+func (c *HealthChecker) run(ctx context.Context, h *Handler, server *Server, probe *healthProbe) {
+	defer c.wg.Done()
+
+	// Start within the jitter window, so servers are not checked in lockstep
+	wait := time.Duration(rand.Float64() * probe.jitter * float64(h.healthCheckInterval()))
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		isAlive, ok := h.checkServer(ctx, server, probe)
+		if !ok {
+			return
+		}
+		wait = probe.wait(isAlive, h.healthCheckInterval())
+	}
+}
+
+// checkServer probes one server and flips it once a threshold is crossed,
+// logging only the transitions. It returns the resulting health, and false
+// when ctx was cancelled and the result discarded.
+func (h *Handler) checkServer(ctx context.Context, server *Server, probe *healthProbe) (bool, bool) {
+	err := probe.check(ctx, server.GetHealthUrl())
+	if ctx.Err() != nil {
+		return false, false
+	}
+
+	h.mu.Lock()
+	isAlive := server.IsAlive
+	h.mu.Unlock()
+
+	if probe.record(err, isAlive) {
+		isAlive = !isAlive
+		h.SetAlive(server, isAlive)
+		if isAlive {
+			h.logf("server %s is up", server.Url)
+		} else {
+			h.logf("server %s is down: %v", server.Url, err)
+		}
+	}
+
+	return isAlive, true
+}
+
+func (h *Handler) healthCheckInterval() time.Duration {
+	if h.HealthCheckInterval > 0 {
+		return h.HealthCheckInterval
+	}
+	return defaultHealthCheckInterval
+}
@@ -0,0 +1,346 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"emaiorov/load-balancer/config"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newHealthBackend(t *testing.T) *httptest.Server {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/no-content":
+			w.WriteHeader(http.StatusNoContent)
+		case "/redirect":
+			http.Redirect(w, r, "/no-content", http.StatusMovedPermanently)
+		case "/error":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		case "/echo":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusAccepted)
+				return
+			}
+			w.Write([]byte("host=" + r.Host + " token=" + r.Header.Get("X-Token")))
+		default:
+			w.Write([]byte(`{"status": "ready", "version": 42}`))
+		}
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func TestHealthProbe(t *testing.T) {
+	backend := newHealthBackend(t)
+
+	testCases := []struct {
+		name          string
+		health        string
+		healthConfig  config.HealthCheckConfig
+		expectedAlive bool
+	}{
+		{name: "CaseDefaultAcceptsOk", health: "/", expectedAlive: true},
+		{name: "CaseDefaultAcceptsNoContent", health: "/no-content", expectedAlive: true},
+		{name: "CaseDefaultRejectsServerError", health: "/error", expectedAlive: false},
+		{name: "CaseRedirectNotFollowed", health: "/redirect", expectedAlive: false},
+		{
+			name:          "CaseRedirectExpected",
+			health:        "/redirect",
+			healthConfig:  config.HealthCheckConfig{ExpectedStatus: []string{"200", "300-399"}},
+			expectedAlive: true,
+		},
+		{
+			name:          "CaseBodyContains",
+			health:        "/",
+			healthConfig:  config.HealthCheckConfig{Body: `"status": "ready"`},
+			expectedAlive: true,
+		},
+		{
+			name:          "CaseBodyMissing",
+			health:        "/",
+			healthConfig:  config.HealthCheckConfig{Body: "draining"},
+			expectedAlive: false,
+		},
+		{
+			name:          "CaseBodyRegex",
+			health:        "/",
+			healthConfig:  config.HealthCheckConfig{BodyRegex: `"version": \d+`},
+			expectedAlive: true,
+		},
+		{
+			name:   "CaseHeadersAndHost",
+			health: "/echo",
+			healthConfig: config.HealthCheckConfig{
+				Headers: map[string]string{"host": "internal.example", "X-Token": "secret"},
+				Body:    "host=internal.example token=secret",
+			},
+			expectedAlive: true,
+		},
+		{
+			name:          "CaseMethod",
+			health:        "/echo",
+			healthConfig:  config.HealthCheckConfig{Method: "head", ExpectedStatus: []string{"202"}},
+			expectedAlive: true,
+		},
+		{
+			name:          "CaseTimeout",
+			health:        "/slow",
+			healthConfig:  config.HealthCheckConfig{TimeoutSeconds: 0.05},
+			expectedAlive: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rrHandler := NewRoundRobinHandler([]Server{
+				{
+					ServerConfig: config.ServerConfig{Url: backend.URL, Health: tc.health, HealthCheck: tc.healthConfig},
+					IsAlive:      !tc.expectedAlive,
+				},
+			})
+
+			checkOnce(t, &rrHandler.Handler)
+
+			if rrHandler.Servers[0].IsAlive != tc.expectedAlive {
+				t.Errorf("Wrong health: got %v, want %v", rrHandler.Servers[0].IsAlive, tc.expectedAlive)
+			}
+		})
+	}
+}
+
+func TestHealthProbeConfigErrors(t *testing.T) {
+	for _, healthConfig := range []config.HealthCheckConfig{
+		{ExpectedStatus: []string{"2xx"}},
+		{ExpectedStatus: []string{"299-200"}},
+		{ExpectedStatus: []string{"200-700"}},
+		{BodyRegex: "("},
+		{TimeoutSeconds: -1},
+		{Rise: -1},
+		{UnhealthyIntervalSeconds: -1},
+		{JitterPercent: 101},
+	} {
+		_, err := NewHandler("RoundRobin", []Server{
+			{ServerConfig: config.ServerConfig{Url: "http://backend", HealthCheck: healthConfig}},
+		}, &config.Config{})
+		if err == nil || !strings.Contains(err.Error(), "http://backend") {
+			t.Errorf("Expected error naming the server for health check config %+v, got %v", healthConfig, err)
+		}
+	}
+}
+
+func TestHealthRiseAndFall(t *testing.T) {
+	var healthy atomic.Bool
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer backend.Close()
+
+	var logs bytes.Buffer
+	rrHandler := NewRoundRobinHandler([]Server{
+		{
+			ServerConfig: config.ServerConfig{Url: backend.URL, HealthCheck: config.HealthCheckConfig{Rise: 2, Fall: 3}},
+			IsAlive:      true,
+		},
+	})
+	rrHandler.Logger = log.New(&logs, "", 0)
+	if err := rrHandler.prepareHealthChecks(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	server := rrHandler.Servers[0]
+	probe := rrHandler.probes[server]
+
+	steps := []struct {
+		healthy       bool
+		expectedAlive bool
+	}{
+		{healthy: false, expectedAlive: true},
+		{healthy: false, expectedAlive: true},
+		{healthy: true, expectedAlive: true},
+		{healthy: false, expectedAlive: true},
+		{healthy: false, expectedAlive: true},
+		{healthy: false, expectedAlive: false},
+		{healthy: false, expectedAlive: false},
+		{healthy: true, expectedAlive: false},
+		{healthy: false, expectedAlive: false},
+		{healthy: true, expectedAlive: false},
+		{healthy: true, expectedAlive: true},
+		{healthy: true, expectedAlive: true},
+	}
+	for i, step := range steps {
+		healthy.Store(step.healthy)
+		rrHandler.checkServer(context.Background(), server, probe)
+		if server.IsAlive != step.expectedAlive {
+			t.Errorf("Wrong health after check %d: got %v, want %v", i+1, server.IsAlive, step.expectedAlive)
+		}
+	}
+
+	if down := strings.Count(logs.String(), "is down"); down != 1 {
+		t.Errorf("Wrong number of down transitions logged: got %d, want 1", down)
+	}
+	if up := strings.Count(logs.String(), "is up"); up != 1 {
+		t.Errorf("Wrong number of up transitions logged: got %d, want 1", up)
+	}
+}
+
+func TestHealthCheckInterval(t *testing.T) {
+	testCases := []struct {
+		name         string
+		healthConfig config.HealthCheckConfig
+		isAlive      bool
+		expectedMin  time.Duration
+		expectedMax  time.Duration
+	}{
+		{name: "CaseHandlerDefault", isAlive: true, expectedMin: 2 * time.Second, expectedMax: 2 * time.Second},
+		{
+			name:         "CaseHealthyInterval",
+			healthConfig: config.HealthCheckConfig{IntervalSeconds: 10, UnhealthyIntervalSeconds: 1},
+			isAlive:      true,
+			expectedMin:  10 * time.Second,
+			expectedMax:  10 * time.Second,
+		},
+		{
+			name:         "CaseUnhealthyInterval",
+			healthConfig: config.HealthCheckConfig{IntervalSeconds: 10, UnhealthyIntervalSeconds: 1},
+			expectedMin:  time.Second,
+			expectedMax:  time.Second,
+		},
+		{
+			name:         "CaseUnhealthyDefaultsToInterval",
+			healthConfig: config.HealthCheckConfig{IntervalSeconds: 10},
+			expectedMin:  10 * time.Second,
+			expectedMax:  10 * time.Second,
+		},
+		{
+			name:         "CaseJitter",
+			healthConfig: config.HealthCheckConfig{IntervalSeconds: 10, JitterPercent: 20},
+			isAlive:      true,
+			expectedMin:  10 * time.Second,
+			expectedMax:  12 * time.Second,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			probe, err := newHealthProbe(tc.healthConfig)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+
+			spread := map[time.Duration]bool{}
+			for range 20 {
+				wait := probe.wait(tc.isAlive, 2*time.Second)
+				if wait < tc.expectedMin || wait > tc.expectedMax {
+					t.Fatalf("Wrong interval: got %v, want %v to %v", wait, tc.expectedMin, tc.expectedMax)
+				}
+				spread[wait] = true
+			}
+			if tc.healthConfig.JitterPercent > 0 && len(spread) == 1 {
+				t.Errorf("Jitter did not vary the interval")
+			}
+		})
+	}
+}
+
+// checkOnce checks every server of h once, in order.
+func checkOnce(t *testing.T, h *Handler) {
+	t.Helper()
+
+	if err := h.prepareHealthChecks(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for _, server := range h.serverList() {
+		h.checkServer(context.Background(), server, h.probes[server])
+	}
+}
+
+func TestHealthCheckerIntervals(t *testing.T) {
+	var slowChecks, fastChecks atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fast" {
+			fastChecks.Add(1)
+		} else {
+			slowChecks.Add(1)
+		}
+	}))
+	defer backend.Close()
+
+	rrHandler := NewRoundRobinHandler([]Server{
+		{ServerConfig: config.ServerConfig{Url: backend.URL, Health: "/slow"}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: backend.URL, Health: "/fast", HealthCheck: config.HealthCheckConfig{IntervalSeconds: 0.01}}, IsAlive: true},
+	})
+	rrHandler.HealthCheckInterval = time.Hour
+
+	checker, err := StartHealthChecks(context.Background(), &rrHandler.Handler)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	checker.Stop()
+
+	if slowChecks.Load() != 1 {
+		t.Errorf("Wrong number of checks of the slow server: got %d, want 1", slowChecks.Load())
+	}
+	if fastChecks.Load() < 3 {
+		t.Errorf("Wrong number of checks of the fast server: got %d, want at least 3", fastChecks.Load())
+	}
+}
+
+func TestHealthCheckerHungServer(t *testing.T) {
+	release := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer hung.Close()
+	defer close(release)
+
+	var checks atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		checks.Add(1)
+	}))
+	defer backend.Close()
+
+	rrHandler := NewRoundRobinHandler([]Server{
+		{ServerConfig: config.ServerConfig{Url: hung.URL}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: backend.URL}, IsAlive: true},
+	})
+	rrHandler.HealthCheckInterval = 10 * time.Millisecond
+
+	checker, err := StartHealthChecks(context.Background(), &rrHandler.Handler)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if checks.Load() < 3 {
+		t.Errorf("Hung server delayed the other checks: got %d checks, want at least 3", checks.Load())
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		checker.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("Stop did not cancel the hung check")
+	}
+
+	// A cancelled check is not a failed one
+	if !rrHandler.Servers[0].IsAlive {
+		t.Errorf("Hung server was marked down by the cancelled check")
+	}
+}
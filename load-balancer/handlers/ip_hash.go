@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"emaiorov/load-balancer/config"
+	"fmt"
+	"net/http"
+)
+
+// IPHashHandler pins each client IP to one server, hashing over the full
+// configured server list. When that server is down the next live one in the
+// list takes over, so other clients keep their server.
+type IPHashHandler struct {
+	Handler
+	clientIP KeyFunc
+}
+
+func NewIPHashHandler(servers []Server, hashConfig config.HashConfig) (*IPHashHandler, error) {
+	return newIPHashHandler(serverPointers(servers), hashConfig)
+}
+
+func newIPHashHandler(serversPtrs []*Server, hashConfig config.HashConfig) (*IPHashHandler, error) {
+	clientIP, err := NewClientIPFunc(hashConfig.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	handler := &IPHashHandler{
+		Handler: Handler{
+			Servers: serversPtrs,
+		},
+		clientIP: clientIP,
+	}
+	handler.strategy = handler
+
+	return handler, nil
+}
+
+func (h *IPHashHandler) GetServer(r *http.Request) (*Server, error) {
+	hash := hashKey(h.clientIP(r))
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	count := len(h.Servers)
+	for i := range count {
+		server := h.Servers[(int(hash%uint32(count))+i)%count]
+		if server.Available() {
+			return server, nil
+		}
+	}
+
+	return &Server{}, fmt.Errorf("no active destinations")
+}
+
+func (h *IPHashHandler) Pick(r *http.Request) (*Server, error) {
+	return h.GetServer(r)
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"emaiorov/load-balancer/config"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	testCases := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		expectedIp string
+	}{
+		{
+			name:       "UntrustedPeerHeadersIgnored",
+			remoteAddr: "203.0.113.7:4000",
+			headers:    map[string]string{"X-Forwarded-For": "1.1.1.1"},
+			expectedIp: "203.0.113.7",
+		},
+		{
+			name:       "TrustedPeerUsesXForwardedFor",
+			remoteAddr: "10.0.0.1:4000",
+			headers:    map[string]string{"X-Forwarded-For": "1.1.1.1"},
+			expectedIp: "1.1.1.1",
+		},
+		{
+			name:       "SpoofedEntryLeftOfClientIgnored",
+			remoteAddr: "10.0.0.1:4000",
+			headers:    map[string]string{"X-Forwarded-For": "6.6.6.6, 1.1.1.1, 10.0.0.2"},
+			expectedIp: "1.1.1.1",
+		},
+		{
+			name:       "ForwardedTakesPrecedence",
+			remoteAddr: "10.0.0.1:4000",
+			headers: map[string]string{
+				"Forwarded":       `for=192.0.2.60;proto=http, for="[2001:db8::1]:4711"`,
+				"X-Forwarded-For": "1.1.1.1",
+			},
+			expectedIp: "2001:db8::1",
+		},
+		{
+			name:       "AllHopsTrustedUsesFirst",
+			remoteAddr: "10.0.0.1:4000",
+			headers:    map[string]string{"X-Forwarded-For": "10.0.0.3, 192.168.1.1"},
+			expectedIp: "10.0.0.3",
+		},
+		{
+			name:       "TrustedPeerWithoutHeaders",
+			remoteAddr: "192.168.1.1:4000",
+			expectedIp: "192.168.1.1",
+		},
+	}
+
+	clientIP, err := NewClientIPFunc([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tc.remoteAddr
+			for name, value := range tc.headers {
+				req.Header.Set(name, value)
+			}
+
+			if got := clientIP(req); got != tc.expectedIp {
+				t.Errorf("Wrong client IP: got %s, want %s", got, tc.expectedIp)
+			}
+		})
+	}
+}
+
+func TestClientIPInvalidTrustedProxy(t *testing.T) {
+	if _, err := NewClientIPFunc([]string{"10.0.0.0/33"}); err == nil {
+		t.Errorf("Expected error for invalid trusted proxy")
+	}
+}
+
+func ipRequest(ip string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = ip + ":1234"
+	return req
+}
+
+func TestIPHashFallsBackToNextServer(t *testing.T) {
+	servers := []Server{
+		{ServerConfig: config.ServerConfig{Url: "http://s1"}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: "http://s2"}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: "http://s3"}, IsAlive: true},
+	}
+	ipHandler, err := NewIPHashHandler(servers, config.HashConfig{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	before := map[string]*Server{}
+	for i := range 300 {
+		ip := fmt.Sprintf("198.51.100.%d", i%256)
+		server, _ := ipHandler.GetServer(ipRequest(ip))
+		if previous, ok := before[ip]; ok && previous != server {
+			t.Fatalf("Client %s moved between servers", ip)
+		}
+		before[ip] = server
+	}
+
+	down := ipHandler.Servers[1]
+	ipHandler.SetAlive(down, false)
+
+	for ip, previous := range before {
+		server, _ := ipHandler.GetServer(ipRequest(ip))
+		switch {
+		case previous == down && server != ipHandler.Servers[2]:
+			t.Errorf("Client %s of the dead server went to %s, want the next server", ip, server.Url)
+		case previous != down && server != previous:
+			t.Errorf("Client %s moved from %s to %s", ip, previous.Url, server.Url)
+		}
+	}
+}
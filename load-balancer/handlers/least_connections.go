@@ -3,11 +3,7 @@ package handlers
 import (
 	"cmp"
 	"fmt"
-	"io"
-	"log"
 	"net/http"
-	"net/http/httputil"
-	"net/url"
 	"slices"
 )
 
@@ -17,32 +13,42 @@ type LeastConnectionsHandler struct {
 }
 
 func NewLeastConnectionsHandler(servers []Server) *LeastConnectionsHandler {
+	return newLeastConnectionsHandler(serverPointers(servers))
+}
 
-	var leastCommonMultiple uint = 1
-	serversPtrs := make([]*Server, len(servers))
+func newLeastConnectionsHandler(serversPtrs []*Server) *LeastConnectionsHandler {
+	leastCommonMultiple := assignLoadCosts(serversPtrs)
 
-	for i := range servers {
-		serversPtrs[i] = &servers[i]
+	handler := &LeastConnectionsHandler{
+		Handler: Handler{
+			Servers: serversPtrs,
+		},
+		LCM: leastCommonMultiple,
 	}
+	handler.strategy = handler
+
+	return handler
+}
 
-	for _, server := range serversPtrs {
+// assignLoadCosts resets the load scores and gives each server a LoadCost
+// inversely proportional to its weight, so LoadScore/LoadCost is the number
+// of requests in flight and equal LoadScores mean equal relative load.
+func assignLoadCosts(servers []*Server) uint {
+	var leastCommonMultiple uint = 1
+
+	for _, server := range servers {
 		if server.Weight == 0 {
 			server.Weight = 1
 		}
 		leastCommonMultiple = leastCommonMultiple * server.Weight
 	}
 
-	for _, server := range serversPtrs {
+	for _, server := range servers {
 		server.LoadScore = 0
 		server.LoadCost = leastCommonMultiple / server.Weight
 	}
 
-	return &LeastConnectionsHandler{
-		Handler: Handler{
-			Servers: serversPtrs,
-		},
-		LCM: leastCommonMultiple,
-	}
+	return leastCommonMultiple
 }
 
 func (h *Handler) DecrementScore(server *Server) {
@@ -63,7 +69,7 @@ func (h *LeastConnectionsHandler) GetServer() (*Server, error) {
 
 	for i := 0; i < len(h.Servers); i++ {
 		server := h.Servers[i]
-		if server.IsAlive {
+		if server.Available() {
 			server.LoadScore += server.LoadCost
 			return server, nil
 		}
@@ -72,53 +78,19 @@ func (h *LeastConnectionsHandler) GetServer() (*Server, error) {
 	return &Server{}, fmt.Errorf("no active destinations")
 }
 
-type responseBodyWrapper struct {
-	Body    io.ReadCloser // Embed the original body (so Read() works automatically)
-	server  *Server
-	handler *LeastConnectionsHandler
+// Pick returns the server with the lowest LoadScore.
+func (h *LeastConnectionsHandler) Pick(r *http.Request) (*Server, error) {
+	return h.GetServer()
 }
 
-func (r *responseBodyWrapper) Close() error {
-	r.handler.DecrementScore(r.server)
-	return r.Body.Close()
-}
+// Acquire counts a request routed to server without Pick, see Acquirer.
+func (h *LeastConnectionsHandler) Acquire(server *Server) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 
-func (r *responseBodyWrapper) Read(p []byte) (n int, err error) {
-	return r.Body.Read(p)
+	server.LoadScore += server.LoadCost
 }
 
-func (handler *LeastConnectionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-
-	server, err := handler.GetServer()
-
-	if err != nil {
-		w.WriteHeader(int(http.StatusServiceUnavailable))
-		fmt.Fprintf(w, "All servers failed on health check")
-		return
-	}
-
-	targetUrl, err := url.Parse(server.Url)
-	if err != nil {
-		handler.DecrementScore(server)
-		log.Printf("ERROR: Could not parse server URL %s: %v", server.Url, err)
-		return
-	}
-	proxy := httputil.NewSingleHostReverseProxy(targetUrl)
-
-	proxy.ModifyResponse = func(res *http.Response) error {
-		res.Body = &responseBodyWrapper{
-			Body:    res.Body,
-			server:  server,
-			handler: handler,
-		}
-		return nil
-	}
-
-	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, e error) {
-		log.Printf("Proxy error to %s: %v", server.Url, e)
-		handler.DecrementScore(server)
-		w.WriteHeader(http.StatusBadGateway)
-	}
-
-	proxy.ServeHTTP(w, r)
+func (h *LeastConnectionsHandler) Done(server *Server, result Result) {
+	h.DecrementScore(server)
 }
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Weight of the newest sample in the time to first byte moving average.
+const responseTimeSmoothing = 0.2
+
+type responseTimeStats struct {
+	averageTimeToFirstByte time.Duration
+	samples                uint64
+}
+
+// ServerStats is a snapshot of the numbers a handler based its choice on.
+type ServerStats struct {
+	Url                      string  `json:"url"`
+	IsAlive                  bool    `json:"is_alive"`
+	Available                bool    `json:"available"` // alive, not ejected, no open circuit nor standby
+	InFlight                 int64   `json:"in_flight"`
+	AverageTimeToFirstByteMs float64 `json:"average_time_to_first_byte_ms"`
+	Samples                  uint64  `json:"samples"`
+	Score                    float64 `json:"score"`
+}
+
+// StatsReporter is implemented by handlers exposing per-server statistics.
+type StatsReporter interface {
+	Stats() any
+}
+
+// LeastResponseTimeHandler routes to the live server with the lowest
+// (InFlight+1) x average time to first byte. The time to first byte is taken
+// when the response headers arrive, in ReverseProxy.ModifyResponse.
+type LeastResponseTimeHandler struct {
+	Handler
+	stats map[*Server]*responseTimeStats
+}
+
+func NewLeastResponseTimeHandler(servers []Server) *LeastResponseTimeHandler {
+	return newLeastResponseTimeHandler(serverPointers(servers))
+}
+
+func newLeastResponseTimeHandler(serversPtrs []*Server) *LeastResponseTimeHandler {
+	stats := make(map[*Server]*responseTimeStats, len(serversPtrs))
+
+	for _, server := range serversPtrs {
+		server.InFlight = 0
+		stats[server] = &responseTimeStats{}
+	}
+
+	handler := &LeastResponseTimeHandler{
+		Handler: Handler{
+			Servers: serversPtrs,
+		},
+		stats: stats,
+	}
+	handler.strategy = handler
+
+	return handler
+}
+
+// score is the expected wait for one more request. Servers without samples
+// are expected to answer in unsampled. Callers hold h.mu.
+func (h *LeastResponseTimeHandler) score(server *Server, unsampled time.Duration) float64 {
+	average := h.stats[server].averageTimeToFirstByte
+	if h.stats[server].samples == 0 {
+		average = unsampled
+	}
+	inFlight := atomic.LoadInt64(&server.InFlight)
+	return float64(inFlight+1) * float64(average)
+}
+
+// unsampledTimeToFirstByte is the mean average time to first byte of the
+// available servers with samples, so servers without any neither win every
+// request nor never get one. Callers hold h.mu.
+func (h *LeastResponseTimeHandler) unsampledTimeToFirstByte() time.Duration {
+	var total time.Duration
+	var sampled int64
+	for _, server := range h.Servers {
+		if stats := h.stats[server]; server.Available() && stats.samples > 0 {
+			total += stats.averageTimeToFirstByte
+			sampled++
+		}
+	}
+	if sampled == 0 {
+		return 0
+	}
+	return total / time.Duration(sampled)
+}
+
+func (h *LeastResponseTimeHandler) GetServer() (*Server, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	unsampled := h.unsampledTimeToFirstByte()
+	var best *Server
+	var bestScore float64
+	for _, server := range h.Servers {
+		if !server.Available() {
+			continue
+		}
+		score := h.score(server, unsampled)
+		if best == nil || score < bestScore || (score == bestScore && h.lessBusy(server, best)) {
+			best = server
+			bestScore = score
+		}
+	}
+
+	if best == nil {
+		return &Server{}, fmt.Errorf("no active destinations")
+	}
+
+	atomic.AddInt64(&best.InFlight, 1)
+	return best, nil
+}
+
+// lessBusy breaks ties between equal scores: fewer requests in flight, then
+// fewer samples so servers get sampled in turn. Callers hold h.mu.
+func (h *LeastResponseTimeHandler) lessBusy(a, b *Server) bool {
+	inFlightA, inFlightB := atomic.LoadInt64(&a.InFlight), atomic.LoadInt64(&b.InFlight)
+	if inFlightA != inFlightB {
+		return inFlightA < inFlightB
+	}
+	return h.stats[a].samples < h.stats[b].samples
+}
+
+// Release records the time to first byte of a finished request. A failed
+// request counts as a response taking ewmaFailureLatency, as in PeakEWMA.
+func (h *LeastResponseTimeHandler) Release(server *Server, timeToFirstByte time.Duration, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	atomic.AddInt64(&server.InFlight, -1)
+	if err != nil {
+		timeToFirstByte = max(timeToFirstByte, ewmaFailureLatency)
+	}
+
+	stats := h.stats[server]
+	if stats.samples == 0 {
+		stats.averageTimeToFirstByte = timeToFirstByte
+	} else {
+		stats.averageTimeToFirstByte = time.Duration(responseTimeSmoothing*float64(timeToFirstByte) +
+			(1-responseTimeSmoothing)*float64(stats.averageTimeToFirstByte))
+	}
+	stats.samples++
+}
+
+// Stats returns the inputs of the selection for every server.
+func (h *LeastResponseTimeHandler) Stats() any {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	unsampled := h.unsampledTimeToFirstByte()
+	stats := make([]ServerStats, len(h.Servers))
+	for i, server := range h.Servers {
+		stats[i] = ServerStats{
+			Url:                      server.Url,
+			IsAlive:                  server.IsAlive,
+			Available:                server.Available(),
+			InFlight:                 atomic.LoadInt64(&server.InFlight),
+			AverageTimeToFirstByteMs: float64(h.stats[server].averageTimeToFirstByte) / float64(time.Millisecond),
+			Samples:                  h.stats[server].samples,
+			Score:                    h.score(server, unsampled) / float64(time.Millisecond),
+		}
+	}
+	return stats
+}
+
+func (h *LeastResponseTimeHandler) Pick(r *http.Request) (*Server, error) {
+	return h.GetServer()
+}
+
+// Acquire counts a request routed to server without Pick, see Acquirer.
+func (h *LeastResponseTimeHandler) Acquire(server *Server) {
+	atomic.AddInt64(&server.InFlight, 1)
+}
+
+func (h *LeastResponseTimeHandler) Done(server *Server, result Result) {
+	if result.Skipped || result.Canceled {
+		atomic.AddInt64(&server.InFlight, -1)
+		return
+	}
+	h.Release(server, result.TimeToFirstByte, result.Err)
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"emaiorov/load-balancer/config"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLeastResponseTimeGetServer(t *testing.T) {
+	servers := []Server{
+		{ServerConfig: config.ServerConfig{Url: "http://s1"}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: "http://s2"}, IsAlive: true},
+	}
+	lrtHandler := NewLeastResponseTimeHandler(servers)
+	s1, s2 := lrtHandler.Servers[0], lrtHandler.Servers[1]
+
+	// Unsampled servers are tried first, least busy one wins
+	first, _ := lrtHandler.GetServer()
+	second, _ := lrtHandler.GetServer()
+	if first != s1 || second != s2 {
+		t.Fatalf("Wrong Servers detected: got %s, %s", first.Url, second.Url)
+	}
+	lrtHandler.Release(s1, 100*time.Millisecond, nil)
+	lrtHandler.Release(s2, 300*time.Millisecond, nil)
+
+	testCases := []struct {
+		name        string
+		inFlight    int64
+		expectedUrl string
+	}{
+		{name: "CaseFasterServerIdle", inFlight: 0, expectedUrl: "http://s1"},
+		{name: "CaseFasterServerBusy", inFlight: 3, expectedUrl: "http://s2"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s1.InFlight = tc.inFlight
+			s2.InFlight = 0
+
+			server, err := lrtHandler.GetServer()
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if server.Url != tc.expectedUrl {
+				t.Errorf("Wrong Server detected: got %s, want %s", server.Url, tc.expectedUrl)
+			}
+		})
+	}
+}
+
+func TestLeastResponseTimeStats(t *testing.T) {
+	servers := []Server{
+		{ServerConfig: config.ServerConfig{Url: "http://s1"}, IsAlive: true},
+	}
+	lrtHandler := NewLeastResponseTimeHandler(servers)
+	s1 := lrtHandler.Servers[0]
+
+	for _, sample := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond} {
+		lrtHandler.GetServer()
+		lrtHandler.Release(s1, sample, nil)
+	}
+	lrtHandler.GetServer()
+	lrtHandler.Release(s1, time.Millisecond, errors.New("connection refused"))
+	lrtHandler.GetServer()
+
+	stats := lrtHandler.Stats().([]ServerStats)
+	expected := ServerStats{
+		Url:                      "http://s1",
+		IsAlive:                  true,
+		Available:                true,
+		InFlight:                 1,
+		AverageTimeToFirstByteMs: 296,
+		Samples:                  3,
+		Score:                    592,
+	}
+	if stats[0] != expected {
+		t.Errorf("Wrong stats: got %+v, want %+v", stats[0], expected)
+	}
+
+	lrtHandler.mu.Lock()
+	s1.ejected = true
+	lrtHandler.mu.Unlock()
+	if stats := lrtHandler.Stats().([]ServerStats); !stats[0].IsAlive || stats[0].Available {
+		t.Errorf("Wrong availability of an ejected server: got is_alive %v, available %v", stats[0].IsAlive, stats[0].Available)
+	}
+}
+
+func TestLeastResponseTimeAvoidsRefusingServer(t *testing.T) {
+	refusing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	refusing.Close()
+	backend := newNamedBackend(t, "backend")
+
+	lrtHandler := NewLeastResponseTimeHandler([]Server{
+		{ServerConfig: config.ServerConfig{Url: refusing.URL}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: backend.URL}, IsAlive: true},
+	})
+	lrtHandler.Logger = log.New(io.Discard, "", 0)
+
+	failures := 0
+	for range 50 {
+		if resp, _ := serve(lrtHandler, httptest.NewRequest(http.MethodGet, "/", nil)); resp.StatusCode == http.StatusBadGateway {
+			failures++
+		}
+	}
+	if failures != 1 {
+		t.Errorf("Wrong number of requests to the refusing server: got %d, want 1", failures)
+	}
+}
+
+func TestLeastResponseTimeRecordsTimeToFirstByte(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	servers := []Server{
+		{ServerConfig: config.ServerConfig{Url: backend.URL}, IsAlive: true},
+	}
+	lrtHandler := NewLeastResponseTimeHandler(servers)
+
+	lrtHandler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	stats := lrtHandler.Stats().([]ServerStats)
+	if stats[0].Samples != 1 || stats[0].InFlight != 0 {
+		t.Fatalf("Request not recorded: %+v", stats[0])
+	}
+	if stats[0].AverageTimeToFirstByteMs < 20 {
+		t.Errorf("Wrong time to first byte: got %.2fms, want at least 20ms", stats[0].AverageTimeToFirstByteMs)
+	}
+}
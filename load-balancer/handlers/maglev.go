@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"emaiorov/load-balancer/config"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sync/atomic"
+)
+
+// Smallest prime above 65536, the table size suggested by the Maglev paper.
+const defaultMaglevTableSize = 65537
+
+type maglevPermutation struct {
+	offset uint64
+	skip   uint64
+}
+
+// MaglevHandler implements Google's Maglev hashing. Every server gets a
+// fixed permutation of the lookup table; live servers take turns claiming
+// their next preferred slot, Weight turns per round, until the table is full.
+// Selection is a single table lookup without locking. The table is rebuilt
+// from the cached permutations whenever the live set changes and swapped in
+// atomically; it is nil while no server is available.
+type MaglevHandler struct {
+	Handler
+	key          KeyFunc
+	tableSize    uint64
+	permutations map[*Server]maglevPermutation
+	table        atomic.Pointer[[]*Server]
+}
+
+func NewMaglevHandler(servers []Server, hashConfig config.HashConfig, maglevConfig config.MaglevConfig) (*MaglevHandler, error) {
+	return newMaglevHandler(serverPointers(servers), hashConfig, maglevConfig)
+}
+
+func newMaglevHandler(serversPtrs []*Server, hashConfig config.HashConfig, maglevConfig config.MaglevConfig) (*MaglevHandler, error) {
+	key, err := NewKeyFunc(hashConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	tableSize := maglevConfig.TableSize
+	if tableSize == 0 {
+		tableSize = defaultMaglevTableSize
+	}
+	if !isPrime(tableSize) {
+		return nil, fmt.Errorf("maglev table size must be prime, got %d", tableSize)
+	}
+
+	permutations := make(map[*Server]maglevPermutation, len(serversPtrs))
+
+	for _, server := range serversPtrs {
+		if server.Weight == 0 {
+			server.Weight = 1
+		}
+		permutations[server] = maglevPermutation{
+			offset: hashString(server.Url, "offset") % uint64(tableSize),
+			skip:   hashString(server.Url, "skip")%uint64(tableSize-1) + 1,
+		}
+	}
+
+	handler := &MaglevHandler{
+		Handler: Handler{
+			Servers: serversPtrs,
+		},
+		key:          key,
+		tableSize:    uint64(tableSize),
+		permutations: permutations,
+	}
+	handler.strategy = handler
+	handler.updateMembership()
+
+	return handler, nil
+}
+
+// UpdateMembership fills a new lookup table from the available servers and
+// swaps it in, off the request path.
+func (h *MaglevHandler) UpdateMembership(servers []*Server) {
+	if len(servers) == 0 {
+		h.table.Store(nil)
+		return
+	}
+
+	table := make([]*Server, h.tableSize)
+	next := make([]uint64, len(servers))
+	var filled uint64
+
+	for {
+		for i, server := range servers {
+			permutation := h.permutations[server]
+			for range server.Weight {
+				slot := (permutation.offset + next[i]*permutation.skip) % h.tableSize
+				for table[slot] != nil {
+					next[i]++
+					slot = (permutation.offset + next[i]*permutation.skip) % h.tableSize
+				}
+				table[slot] = server
+				next[i]++
+				filled++
+				if filled == h.tableSize {
+					h.table.Store(&table)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (h *MaglevHandler) GetServer(r *http.Request) (*Server, error) {
+	table := h.table.Load()
+	if table == nil {
+		return &Server{}, fmt.Errorf("no active destinations")
+	}
+
+	return (*table)[uint64(hashKey(h.key(r)))%h.tableSize], nil
+}
+
+func (h *MaglevHandler) Pick(r *http.Request) (*Server, error) {
+	return h.GetServer(r)
+}
+
+func hashString(value string, salt string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(salt))
+	hash.Write([]byte(value))
+	return hash.Sum64()
+}
+
+func isPrime(n int) bool {
+	if n < 2 {
+		return false
+	}
+	for i := 2; i*i <= n; i++ {
+		if n%i == 0 {
+			return false
+		}
+	}
+	return true
+}
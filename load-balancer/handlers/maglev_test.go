@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"emaiorov/load-balancer/config"
+	"fmt"
+	"testing"
+)
+
+var maglevHashConfig = config.HashConfig{Key: "header", Name: "X-Key"}
+
+func TestMaglevTableIsWeighted(t *testing.T) {
+	servers := newPoolServers(2)
+	servers[1].Weight = 3
+
+	mHandler, err := NewMaglevHandler(servers, maglevHashConfig, config.MaglevConfig{TableSize: 4099})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	table := *mHandler.table.Load()
+	counts := map[*Server]int{}
+	for _, server := range table {
+		if server == nil {
+			t.Fatalf("Lookup table has an empty slot")
+		}
+		counts[server]++
+	}
+
+	// Weighted turns give an exact 1:3 split of the table, give or take a round
+	light := counts[mHandler.Servers[0]]
+	if light < 1023 || light > 1026 {
+		t.Errorf("Wrong table share for weight 1: got %d of %d", light, len(table))
+	}
+}
+
+func TestMaglevRemappingWhenServerGoesDown(t *testing.T) {
+	testCases := []int{5, 10}
+
+	for _, serverCount := range testCases {
+		t.Run(fmt.Sprintf("Servers%d", serverCount), func(t *testing.T) {
+			mHandler, err := NewMaglevHandler(newPoolServers(serverCount), maglevHashConfig, config.MaglevConfig{})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+
+			const keys = 20000
+			before := make([]*Server, keys)
+			for i := range keys {
+				before[i], _ = mHandler.GetServer(hashRequest(fmt.Sprintf("key-%d", i)))
+			}
+
+			down := mHandler.Servers[serverCount/2]
+			mHandler.SetAlive(down, false)
+
+			remapped := 0
+			for i := range keys {
+				server, err := mHandler.GetServer(hashRequest(fmt.Sprintf("key-%d", i)))
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				if server == down {
+					t.Fatalf("Key routed to the server that went down")
+				}
+				if before[i] != down && server != before[i] {
+					remapped++
+				}
+			}
+
+			percentage := 100 * float64(remapped) / keys
+			t.Logf("%d servers: %.2f%% of keys on surviving servers were remapped", serverCount, percentage)
+			if percentage > 3 {
+				t.Errorf("Too much disruption: %.2f%% of keys on surviving servers were remapped", percentage)
+			}
+		})
+	}
+}
+
+func TestMaglevRebuildsWhenServerComesBack(t *testing.T) {
+	mHandler, _ := NewMaglevHandler(newPoolServers(3), maglevHashConfig, config.MaglevConfig{TableSize: 251})
+
+	for _, server := range mHandler.Servers {
+		mHandler.SetAlive(server, false)
+	}
+	if _, err := mHandler.GetServer(hashRequest("key")); err == nil {
+		t.Fatalf("Expected error that no servers found")
+	}
+
+	mHandler.SetAlive(mHandler.Servers[2], true)
+	if mHandler.table.Load() == nil {
+		t.Fatalf("Lookup table not rebuilt before the next request")
+	}
+	server, err := mHandler.GetServer(hashRequest("key"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if server != mHandler.Servers[2] {
+		t.Errorf("Wrong Server detected: got %s, want %s", server.Url, mHandler.Servers[2].Url)
+	}
+}
+
+func TestMaglevRejectsTableSizeNotPrime(t *testing.T) {
+	_, err := NewMaglevHandler(newPoolServers(3), maglevHashConfig, config.MaglevConfig{TableSize: 65536})
+	if err == nil {
+		t.Errorf("Expected error for table size that is not prime")
+	}
+}
@@ -0,0 +1,271 @@
+package handlers
+
+import (
+	"emaiorov/load-balancer/config"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	defaultOutlierConsecutiveErrors      = 5
+	defaultOutlierInterval               = 10 * time.Second
+	defaultOutlierBaseEjection           = 30 * time.Second
+	defaultOutlierMaxEjection            = 300 * time.Second
+	defaultOutlierMaxEjectionPercent     = 10
+	defaultOutlierMinHosts               = 5
+	defaultOutlierRequestVolume          = 100
+	defaultOutlierSuccessRateStdevFactor = 1.9
+)
+
+type outlierDetection struct {
+	consecutiveErrors  int
+	interval           time.Duration
+	baseEjection       time.Duration
+	maxEjection        time.Duration
+	maxEjectionPercent int
+	minHosts           int
+	requestVolume      int
+	stdevFactor        float64
+
+	// mu is taken before h.mu when both are needed
+	mu          sync.Mutex
+	servers     map[*Server]*outlierStats
+	intervalEnd time.Time
+}
+
+type outlierStats struct {
+	consecutiveErrors int
+	requests          int
+	successes         int
+	ejections         int
+	ejectedUntil      time.Time
+	unejectTimer      *time.Timer
+}
+
+// EnableOutlierDetection ejects servers failing the requests proxied to
+// them, so they stop getting traffic before the next health check notices.
+// Ejected servers come back on their own once their ejection time is up.
+// It must be called before the first request.
+func (h *Handler) EnableOutlierDetection(outlierConfig config.OutlierConfig) error {
+	if outlierConfig.ConsecutiveErrors < 0 || outlierConfig.IntervalSeconds < 0 ||
+		outlierConfig.BaseEjectionSeconds < 0 || outlierConfig.MaxEjectionSeconds < 0 ||
+		outlierConfig.SuccessRateMinHosts < 0 || outlierConfig.SuccessRateRequestVolume < 0 ||
+		outlierConfig.SuccessRateStdevFactor < 0 {
+		return fmt.Errorf("outlier detection settings must not be negative")
+	}
+	if outlierConfig.MaxEjectionPercent < 0 || outlierConfig.MaxEjectionPercent > 100 {
+		return fmt.Errorf("max ejection percent must be between 0 and 100, got %d", outlierConfig.MaxEjectionPercent)
+	}
+
+	orDefault := func(value int, fallback int) int {
+		if value > 0 {
+			return value
+		}
+		return fallback
+	}
+
+	outliers := &outlierDetection{
+		consecutiveErrors:  orDefault(outlierConfig.ConsecutiveErrors, defaultOutlierConsecutiveErrors),
+		interval:           secondsOr(outlierConfig.IntervalSeconds, defaultOutlierInterval),
+		baseEjection:       secondsOr(outlierConfig.BaseEjectionSeconds, defaultOutlierBaseEjection),
+		maxEjection:        secondsOr(outlierConfig.MaxEjectionSeconds, defaultOutlierMaxEjection),
+		maxEjectionPercent: orDefault(outlierConfig.MaxEjectionPercent, defaultOutlierMaxEjectionPercent),
+		minHosts:           orDefault(outlierConfig.SuccessRateMinHosts, defaultOutlierMinHosts),
+		requestVolume:      orDefault(outlierConfig.SuccessRateRequestVolume, defaultOutlierRequestVolume),
+		stdevFactor:        outlierConfig.SuccessRateStdevFactor,
+	}
+	if outliers.stdevFactor == 0 {
+		outliers.stdevFactor = defaultOutlierSuccessRateStdevFactor
+	}
+	outliers.maxEjection = max(outliers.maxEjection, outliers.baseEjection)
+
+	servers := h.serverList()
+	outliers.servers = make(map[*Server]*outlierStats, len(servers))
+	for _, server := range servers {
+		outliers.servers[server] = &outlierStats{}
+	}
+	outliers.intervalEnd = time.Now().Add(outliers.interval)
+
+	h.mu.Lock()
+	h.outliers = outliers
+	h.mu.Unlock()
+
+	return nil
+}
+
+// observeOutlier records whether a request proxied to server failed, with a
+// 5xx response or a proxy error. Success rates are compared on the first
+// request after each interval.
+func (h *Handler) observeOutlier(server *Server, failed bool) {
+	o := h.outliers
+	if o == nil {
+		return
+	}
+
+	o.mu.Lock()
+	stats := o.servers[server]
+	if stats == nil {
+		o.mu.Unlock()
+		return
+	}
+
+	stats.requests++
+	if failed {
+		stats.consecutiveErrors++
+	} else {
+		stats.successes++
+		stats.consecutiveErrors = 0
+	}
+
+	type ejection struct {
+		server   *Server
+		duration time.Duration
+		reason   string
+	}
+	var ejected []ejection
+
+	if stats.consecutiveErrors >= o.consecutiveErrors {
+		stats.consecutiveErrors = 0
+		if duration, ok := h.eject(server, stats); ok {
+			ejected = append(ejected, ejection{server, duration, fmt.Sprintf("%d errors in a row", o.consecutiveErrors)})
+		}
+	}
+
+	now := time.Now()
+	if now.After(o.intervalEnd) {
+		for _, outlier := range o.successRateOutliers() {
+			if duration, ok := h.eject(outlier, o.servers[outlier]); ok {
+				ejected = append(ejected, ejection{outlier, duration, "low success rate"})
+			}
+		}
+		for _, s := range o.servers {
+			s.requests, s.successes = 0, 0
+			// Servers staying in since the last interval earn back a shorter
+			// next ejection
+			if s.ejections > 0 && now.After(s.ejectedUntil.Add(o.interval)) {
+				s.ejections--
+			}
+		}
+		o.intervalEnd = now.Add(o.interval)
+	}
+	o.mu.Unlock()
+
+	if len(ejected) > 0 {
+		h.updateMembership()
+	}
+	for _, e := range ejected {
+		h.logf("server %s ejected for %v: %s", e.server.Url, e.duration, e.reason)
+	}
+}
+
+// successRateOutliers returns the servers whose success rate in the last
+// interval is more than stdevFactor standard deviations below the mean.
+// Callers hold o.mu.
+func (o *outlierDetection) successRateOutliers() []*Server {
+	rates := make(map[*Server]float64)
+	var sum float64
+	for server, stats := range o.servers {
+		if stats.requests >= o.requestVolume {
+			rate := float64(stats.successes) / float64(stats.requests)
+			rates[server] = rate
+			sum += rate
+		}
+	}
+	if len(rates) == 0 || len(rates) < o.minHosts {
+		return nil
+	}
+
+	mean := sum / float64(len(rates))
+	var variance float64
+	for _, rate := range rates {
+		variance += (rate - mean) * (rate - mean)
+	}
+	threshold := mean - o.stdevFactor*math.Sqrt(variance/float64(len(rates)))
+
+	var outliers []*Server
+	for server, rate := range rates {
+		if rate < threshold {
+			outliers = append(outliers, server)
+		}
+	}
+	return outliers
+}
+
+// eject takes server out of rotation for a time doubling with each of its
+// ejections, unless that would eject more than maxEjectionPercent of the
+// servers. Callers hold o.mu.
+func (h *Handler) eject(server *Server, stats *outlierStats) (time.Duration, bool) {
+	o := h.outliers
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if server.ejected {
+		return 0, false
+	}
+	ejected := 0
+	for _, s := range h.Servers {
+		if s.ejected {
+			ejected++
+		}
+	}
+	if ejected*100 >= o.maxEjectionPercent*len(h.Servers) {
+		return 0, false
+	}
+
+	duration := o.baseEjection
+	for i := 0; i < stats.ejections && duration < o.maxEjection; i++ {
+		duration *= 2
+	}
+	duration = min(duration, o.maxEjection)
+	stats.ejections++
+	stats.ejectedUntil = time.Now().Add(duration)
+
+	server.ejected = true
+	h.generation.Add(1)
+	h.updateTiers()
+
+	stats.unejectTimer = time.AfterFunc(duration, func() { h.uneject(server) })
+
+	return duration, true
+}
+
+// stopEjections returns the ejected servers to rotation right away, stopping
+// the timers that would have returned them later.
+func (h *Handler) stopEjections() {
+	o := h.outliers
+	if o == nil {
+		return
+	}
+
+	var ejected []*Server
+	o.mu.Lock()
+	for server, stats := range o.servers {
+		if stats.unejectTimer != nil && stats.unejectTimer.Stop() {
+			ejected = append(ejected, server)
+		}
+		stats.unejectTimer = nil
+	}
+	o.mu.Unlock()
+
+	for _, server := range ejected {
+		h.uneject(server)
+	}
+}
+
+func (h *Handler) uneject(server *Server) {
+	h.mu.Lock()
+	if !server.ejected {
+		h.mu.Unlock()
+		return
+	}
+	server.ejected = false
+	h.generation.Add(1)
+	h.updateTiers()
+	h.mu.Unlock()
+
+	h.updateMembership()
+	h.logf("server %s returned from ejection", server.Url)
+}
@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"emaiorov/load-balancer/config"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutlierConsecutiveErrors(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "failing", http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	backend1 := newNamedBackend(t, "backend-1")
+	backend2 := newNamedBackend(t, "backend-2")
+
+	handler, err := NewHandler("RoundRobin", []Server{
+		{ServerConfig: config.ServerConfig{Url: failing.URL}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: backend1.URL}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: backend2.URL}, IsAlive: true},
+	}, &config.Config{Outlier: config.OutlierConfig{
+		Enabled:             true,
+		ConsecutiveErrors:   2,
+		BaseEjectionSeconds: 0.1,
+		MaxEjectionPercent:  50,
+	}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	failures := 0
+	for range 12 {
+		if body, _ := sendWithCookie(handler, nil); strings.Contains(body, "failing") {
+			failures++
+		}
+	}
+	if failures != 2 {
+		t.Errorf("Wrong number of requests to the failing server: got %d, want 2", failures)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	failures = 0
+	for range 6 {
+		if body, _ := sendWithCookie(handler, nil); strings.Contains(body, "failing") {
+			failures++
+		}
+	}
+	if failures == 0 {
+		t.Errorf("Failing server did not return after its ejection")
+	}
+}
+
+func TestOutlierIgnoresClientCancellation(t *testing.T) {
+	testCases := []struct {
+		name            string
+		retry           config.RetryConfig
+		expectedEjected bool
+	}{
+		{
+			name:            "CaseClientTimeout",
+			expectedEjected: false,
+		},
+		{
+			name:            "CasePerTryTimeout",
+			retry:           config.RetryConfig{MaxAttempts: 2, PerTryTimeoutSeconds: 0.02},
+			expectedEjected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			slow := newHangingBackend(t)
+			backend := newNamedBackend(t, "backend")
+
+			handler, err := NewHandler("RoundRobin", []Server{
+				{ServerConfig: config.ServerConfig{Url: slow.URL}, IsAlive: true},
+				{ServerConfig: config.ServerConfig{Url: backend.URL}, IsAlive: true},
+			}, &config.Config{
+				Outlier: config.OutlierConfig{
+					Enabled:             true,
+					ConsecutiveErrors:   2,
+					BaseEjectionSeconds: 10,
+					MaxEjectionPercent:  50,
+				},
+				Retry: tc.retry,
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			handler.Logger = log.New(io.Discard, "", 0)
+
+			for range 10 {
+				ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+				req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+				handler.ServeHTTP(httptest.NewRecorder(), req)
+				cancel()
+			}
+
+			handler.mu.Lock()
+			ejected := handler.Servers[0].ejected
+			handler.mu.Unlock()
+			if ejected != tc.expectedEjected {
+				t.Errorf("Wrong ejection of the slow server: got %v, want %v", ejected, tc.expectedEjected)
+			}
+		})
+	}
+}
+
+func TestOutlierMaxEjectionPercent(t *testing.T) {
+	handler := NewRoundRobinHandler([]Server{
+		{ServerConfig: config.ServerConfig{Url: "http://server1"}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: "http://server2"}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: "http://server3"}, IsAlive: true},
+	})
+	if err := handler.EnableOutlierDetection(config.OutlierConfig{ConsecutiveErrors: 1, BaseEjectionSeconds: 3600, MaxEjectionPercent: 50}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	for _, server := range handler.Servers {
+		handler.observeOutlier(server, true)
+	}
+
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	ejected := 0
+	for _, server := range handler.Servers {
+		if server.ejected {
+			ejected++
+		}
+	}
+	if ejected != 2 {
+		t.Errorf("Wrong number of ejected servers: got %d, want 2", ejected)
+	}
+}
+
+func TestOutlierEjectionDuration(t *testing.T) {
+	var logs bytes.Buffer
+	handler := NewRoundRobinHandler([]Server{
+		{ServerConfig: config.ServerConfig{Url: "http://server1"}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: "http://server2"}, IsAlive: true},
+	})
+	handler.Logger = log.New(&logs, "", 0)
+	if err := handler.EnableOutlierDetection(config.OutlierConfig{
+		ConsecutiveErrors:   1,
+		BaseEjectionSeconds: 3600,
+		MaxEjectionSeconds:  3 * 3600,
+		MaxEjectionPercent:  50,
+	}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	server := handler.Servers[0]
+	for _, expected := range []string{"1h0m0s", "2h0m0s", "3h0m0s", "3h0m0s"} {
+		logs.Reset()
+		handler.observeOutlier(server, true)
+		if !strings.Contains(logs.String(), "ejected for "+expected) {
+			t.Errorf("Wrong ejection: got %q, want %s", logs.String(), expected)
+		}
+		handler.uneject(server)
+	}
+}
+
+func TestOutlierStopEndsEjections(t *testing.T) {
+	var logs bytes.Buffer
+	handler := NewRoundRobinHandler([]Server{
+		{ServerConfig: config.ServerConfig{Url: "http://server1"}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: "http://server2"}, IsAlive: true},
+	})
+	handler.Logger = log.New(&logs, "", 0)
+	if err := handler.EnableOutlierDetection(config.OutlierConfig{
+		ConsecutiveErrors:   1,
+		BaseEjectionSeconds: 0.05,
+		MaxEjectionPercent:  50,
+	}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	server := handler.Servers[0]
+	handler.observeOutlier(server, true)
+	if !server.ejected {
+		t.Fatalf("Server was not ejected")
+	}
+
+	handler.Stop()
+	if server.ejected {
+		t.Errorf("Server still ejected after Stop")
+	}
+
+	// The ejection timer does not fire once stopped
+	logs.Reset()
+	time.Sleep(80 * time.Millisecond)
+	if logs.Len() != 0 {
+		t.Errorf("Ejection timer fired after Stop: %q", logs.String())
+	}
+}
+
+func TestOutlierSuccessRate(t *testing.T) {
+	var servers []Server
+	for _, url := range []string{"http://server1", "http://server2", "http://server3", "http://server4", "http://server5"} {
+		servers = append(servers, Server{ServerConfig: config.ServerConfig{Url: url}, IsAlive: true})
+	}
+	handler := NewRoundRobinHandler(servers)
+	if err := handler.EnableOutlierDetection(config.OutlierConfig{
+		ConsecutiveErrors:        100,
+		IntervalSeconds:          0.05,
+		BaseEjectionSeconds:      3600,
+		MaxEjectionPercent:       100,
+		SuccessRateRequestVolume: 10,
+	}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	// The last server fails every other request
+	for i := range 20 {
+		for j, server := range handler.Servers {
+			handler.observeOutlier(server, j == 4 && i%2 == 0)
+		}
+	}
+	time.Sleep(60 * time.Millisecond)
+	handler.observeOutlier(handler.Servers[0], false)
+
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	for i, server := range handler.Servers {
+		if server.ejected != (i == 4) {
+			t.Errorf("Wrong ejection of server %d: got %v, want %v", i, server.ejected, i == 4)
+		}
+	}
+}
+
+func TestEnableOutlierDetectionErrors(t *testing.T) {
+	for _, outlierConfig := range []config.OutlierConfig{
+		{ConsecutiveErrors: -1},
+		{BaseEjectionSeconds: -1},
+		{MaxEjectionPercent: 101},
+	} {
+		h := &Handler{}
+		if err := h.EnableOutlierDetection(outlierConfig); err == nil {
+			t.Errorf("Expected error for %+v", outlierConfig)
+		}
+	}
+}
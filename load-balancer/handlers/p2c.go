@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"sync/atomic"
+)
+
+type liveSnapshot struct {
+	generation uint64
+	servers    []*Server
+}
+
+// PowerOfTwoChoicesHandler picks two random live servers and sends the
+// request to the one with fewer weighted in-flight requests. In-flight
+// counters are atomic and the live set is an immutable snapshot rebuilt only
+// when health changes, so picking does not take the handler mutex.
+type PowerOfTwoChoicesHandler struct {
+	Handler
+	live atomic.Pointer[liveSnapshot]
+}
+
+func NewPowerOfTwoChoicesHandler(servers []Server) *PowerOfTwoChoicesHandler {
+	return newPowerOfTwoChoicesHandler(serverPointers(servers))
+}
+
+func newPowerOfTwoChoicesHandler(serversPtrs []*Server) *PowerOfTwoChoicesHandler {
+	for _, server := range serversPtrs {
+		if server.Weight == 0 {
+			server.Weight = 1
+		}
+		server.InFlight = 0
+	}
+
+	handler := &PowerOfTwoChoicesHandler{
+		Handler: Handler{
+			Servers: serversPtrs,
+		},
+	}
+	handler.strategy = handler
+
+	return handler
+}
+
+func (h *PowerOfTwoChoicesHandler) liveServers() []*Server {
+	if snapshot := h.live.Load(); snapshot != nil && snapshot.generation == h.generation.Load() {
+		return snapshot.servers
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	snapshot := &liveSnapshot{generation: h.generation.Load()}
+	for _, server := range h.Servers {
+		if server.Available() {
+			snapshot.servers = append(snapshot.servers, server)
+		}
+	}
+	h.live.Store(snapshot)
+
+	return snapshot.servers
+}
+
+func (h *PowerOfTwoChoicesHandler) GetServer() (*Server, error) {
+	live := h.liveServers()
+
+	var server *Server
+	switch len(live) {
+	case 0:
+		return &Server{}, fmt.Errorf("no active destinations")
+	case 1:
+		server = live[0]
+	default:
+		i := rand.IntN(len(live))
+		j := rand.IntN(len(live) - 1)
+		if j >= i {
+			j++
+		}
+		server = lessLoaded(live[i], live[j])
+	}
+
+	atomic.AddInt64(&server.InFlight, 1)
+	return server, nil
+}
+
+// lessLoaded compares (InFlight+1)/Weight of both servers without dividing.
+func lessLoaded(a, b *Server) *Server {
+	loadA := (atomic.LoadInt64(&a.InFlight) + 1) * int64(b.Weight)
+	loadB := (atomic.LoadInt64(&b.InFlight) + 1) * int64(a.Weight)
+	if loadB < loadA {
+		return b
+	}
+	return a
+}
+
+func (h *PowerOfTwoChoicesHandler) Release(server *Server) {
+	atomic.AddInt64(&server.InFlight, -1)
+}
+
+func (h *PowerOfTwoChoicesHandler) Pick(r *http.Request) (*Server, error) {
+	return h.GetServer()
+}
+
+// Acquire counts a request routed to server without Pick, see Acquirer.
+func (h *PowerOfTwoChoicesHandler) Acquire(server *Server) {
+	atomic.AddInt64(&server.InFlight, 1)
+}
+
+func (h *PowerOfTwoChoicesHandler) Done(server *Server, result Result) {
+	h.Release(server)
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"emaiorov/load-balancer/config"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPowerOfTwoChoicesGetServer(t *testing.T) {
+	testCases := []struct {
+		name        string
+		servers     []Server
+		expectedUrl string
+	}{
+		{
+			name: "CaseWithSameWeights",
+			servers: []Server{
+				{ServerConfig: config.ServerConfig{Url: "http://s1", Weight: 1}, IsAlive: true},
+				{ServerConfig: config.ServerConfig{Url: "http://s2", Weight: 1}, IsAlive: true},
+			},
+			expectedUrl: "http://s2",
+		},
+		{
+			name: "CaseWithHeavierServerLessLoaded",
+			servers: []Server{
+				{ServerConfig: config.ServerConfig{Url: "http://s1", Weight: 4}, IsAlive: true},
+				{ServerConfig: config.ServerConfig{Url: "http://s2", Weight: 1}, IsAlive: true},
+			},
+			expectedUrl: "http://s1",
+		},
+		{
+			name: "CaseWithOneAliveServer",
+			servers: []Server{
+				{ServerConfig: config.ServerConfig{Url: "http://s1", Weight: 1}, IsAlive: true},
+				{ServerConfig: config.ServerConfig{Url: "http://s2", Weight: 1}, IsAlive: false},
+			},
+			expectedUrl: "http://s1",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p2cHandler := NewPowerOfTwoChoicesHandler(tc.servers)
+			// s1 already serves two requests
+			p2cHandler.Servers[0].InFlight = 2
+
+			server, err := p2cHandler.GetServer()
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if server.Url != tc.expectedUrl {
+				t.Errorf("Wrong Server detected: got %s, want %s", server.Url, tc.expectedUrl)
+			}
+		})
+	}
+}
+
+func TestPowerOfTwoChoicesFollowsHealthChanges(t *testing.T) {
+	servers := []Server{
+		{ServerConfig: config.ServerConfig{Url: "http://s1", Weight: 1}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: "http://s2", Weight: 1}, IsAlive: true},
+	}
+	p2cHandler := NewPowerOfTwoChoicesHandler(servers)
+
+	server, _ := p2cHandler.GetServer()
+	p2cHandler.Release(server)
+	if server.InFlight != 0 {
+		t.Errorf("Wrong InFlight after release: got %d, want 0", server.InFlight)
+	}
+
+	p2cHandler.SetAlive(p2cHandler.Servers[0], false)
+	p2cHandler.SetAlive(p2cHandler.Servers[1], false)
+	if _, err := p2cHandler.GetServer(); err == nil {
+		t.Fatalf("Expected error that no servers found")
+	}
+
+	p2cHandler.SetAlive(p2cHandler.Servers[1], true)
+	server, err := p2cHandler.GetServer()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if server.Url != "http://s2" {
+		t.Errorf("Wrong Server detected: got %s, want http://s2", server.Url)
+	}
+}
+
+func newBenchmarkServers() []Server {
+	servers := make([]Server, 10)
+	for i := range servers {
+		servers[i] = Server{
+			ServerConfig: config.ServerConfig{Url: fmt.Sprintf("http://s%d", i), Weight: uint(i%3 + 1)},
+			IsAlive:      true,
+		}
+	}
+	return servers
+}
+
+// okTransport answers every request itself, so benchmarks of ServeHTTP
+// measure the balancer rather than the network.
+type okTransport struct{}
+
+func (okTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: http.NoBody, Request: r}, nil
+}
+
+func benchmarkServeHTTP(b *testing.B, handler *Handler) {
+	handler.Transport = okTransport{}
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		for pb.Next() {
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+		}
+	})
+}
+
+func BenchmarkLeastConnectionsServeHTTPParallel(b *testing.B) {
+	benchmarkServeHTTP(b, &NewLeastConnectionsHandler(newBenchmarkServers()).Handler)
+}
+
+func BenchmarkPowerOfTwoChoicesServeHTTPParallel(b *testing.B) {
+	benchmarkServeHTTP(b, &NewPowerOfTwoChoicesHandler(newBenchmarkServers()).Handler)
+}
+
+func BenchmarkLeastConnectionsGetServerParallel(b *testing.B) {
+	lcHandler := NewLeastConnectionsHandler(newBenchmarkServers())
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			server, _ := lcHandler.GetServer()
+			lcHandler.DecrementScore(server)
+		}
+	})
+}
+
+func BenchmarkPowerOfTwoChoicesGetServerParallel(b *testing.B) {
+	p2cHandler := NewPowerOfTwoChoicesHandler(newBenchmarkServers())
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			server, _ := p2cHandler.GetServer()
+			p2cHandler.Release(server)
+		}
+	})
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"emaiorov/load-balancer/config"
+	"fmt"
+	"math"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultEWMADecay = 10 * time.Second
+	// A failed request counts as a response this slow, so a backend that
+	// refuses connections quickly does not look fast
+	ewmaFailureLatency = time.Second
+	// Load of a server with requests in flight but no latency sample yet
+	ewmaPenalty = float64(math.MaxInt64 >> 16)
+)
+
+type ewmaStats struct {
+	cost  float64 // nanoseconds
+	stamp time.Time
+}
+
+// PeakEWMAHandler routes to the live server with the lowest latency cost
+// multiplied by its in-flight requests, like Finagle's peak EWMA balancer.
+// The cost is an exponentially weighted moving average of response latency
+// that jumps straight to any sample above it and decays back over DecayTime,
+// so a server turning slow is avoided immediately. Weights are not used.
+type PeakEWMAHandler struct {
+	Handler
+	DecayTime time.Duration
+	stats     map[*Server]*ewmaStats
+	now       func() time.Time
+}
+
+func NewPeakEWMAHandler(servers []Server, ewmaConfig config.PeakEWMAConfig) *PeakEWMAHandler {
+	return newPeakEWMAHandler(serverPointers(servers), ewmaConfig)
+}
+
+func newPeakEWMAHandler(serversPtrs []*Server, ewmaConfig config.PeakEWMAConfig) *PeakEWMAHandler {
+	decayTime := secondsOr(ewmaConfig.DecaySeconds, defaultEWMADecay)
+
+	stats := make(map[*Server]*ewmaStats, len(serversPtrs))
+	now := time.Now()
+
+	for _, server := range serversPtrs {
+		server.InFlight = 0
+		stats[server] = &ewmaStats{stamp: now}
+	}
+
+	handler := &PeakEWMAHandler{
+		Handler: Handler{
+			Servers: serversPtrs,
+		},
+		DecayTime: decayTime,
+		stats:     stats,
+		now:       time.Now,
+	}
+	handler.strategy = handler
+
+	return handler
+}
+
+// observe folds a latency sample into the server cost. Callers hold h.mu.
+func (h *PeakEWMAHandler) observe(server *Server, rtt time.Duration, now time.Time) {
+	stats := h.stats[server]
+	elapsed := max(now.Sub(stats.stamp), 0)
+	weight := math.Exp(-float64(elapsed) / float64(h.DecayTime))
+
+	sample := float64(rtt)
+	if sample > stats.cost {
+		stats.cost = sample
+	} else {
+		stats.cost = stats.cost*weight + sample*(1-weight)
+	}
+	stats.stamp = now
+}
+
+// load returns the decayed cost times the pending requests. Callers hold h.mu.
+func (h *PeakEWMAHandler) load(server *Server, now time.Time) float64 {
+	h.observe(server, 0, now)
+
+	cost := h.stats[server].cost
+	pending := atomic.LoadInt64(&server.InFlight)
+	if cost == 0 && pending != 0 {
+		return ewmaPenalty + float64(pending)
+	}
+	return cost * float64(pending+1)
+}
+
+func (h *PeakEWMAHandler) GetServer() (*Server, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.now()
+	var best *Server
+	var bestLoad float64
+	for _, server := range h.Servers {
+		if !server.Available() {
+			continue
+		}
+		load := h.load(server, now)
+		if best == nil || load < bestLoad {
+			best = server
+			bestLoad = load
+		}
+	}
+
+	if best == nil {
+		return &Server{}, fmt.Errorf("no active destinations")
+	}
+
+	atomic.AddInt64(&best.InFlight, 1)
+	return best, nil
+}
+
+// Release records the latency of a finished request.
+func (h *PeakEWMAHandler) Release(server *Server, rtt time.Duration, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err != nil {
+		rtt = max(rtt, ewmaFailureLatency)
+	}
+	h.observe(server, rtt, h.now())
+	atomic.AddInt64(&server.InFlight, -1)
+}
+
+func (h *PeakEWMAHandler) Pick(r *http.Request) (*Server, error) {
+	return h.GetServer()
+}
+
+// Acquire counts a request routed to server without Pick, see Acquirer.
+func (h *PeakEWMAHandler) Acquire(server *Server) {
+	atomic.AddInt64(&server.InFlight, 1)
+}
+
+func (h *PeakEWMAHandler) Done(server *Server, result Result) {
+	if result.Skipped || result.Canceled {
+		atomic.AddInt64(&server.InFlight, -1)
+		return
+	}
+	h.Release(server, result.Duration, result.Err)
+}
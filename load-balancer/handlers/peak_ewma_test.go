@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"emaiorov/load-balancer/config"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newPeakEWMATestHandler() (*PeakEWMAHandler, *time.Time) {
+	servers := []Server{
+		{ServerConfig: config.ServerConfig{Url: "http://fast"}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: "http://slow"}, IsAlive: true},
+	}
+	ewmaHandler := NewPeakEWMAHandler(servers, config.PeakEWMAConfig{DecaySeconds: 10})
+
+	clock := time.Now()
+	ewmaHandler.now = func() time.Time { return clock }
+	for _, stats := range ewmaHandler.stats {
+		stats.stamp = clock
+	}
+	return ewmaHandler, &clock
+}
+
+func TestPeakEWMAPrefersFasterServer(t *testing.T) {
+	ewmaHandler, clock := newPeakEWMATestHandler()
+	fast, slow := ewmaHandler.Servers[0], ewmaHandler.Servers[1]
+
+	for range 5 {
+		*clock = clock.Add(100 * time.Millisecond)
+		ewmaHandler.mu.Lock()
+		ewmaHandler.observe(fast, 200*time.Millisecond, *clock)
+		ewmaHandler.observe(slow, 1200*time.Millisecond, *clock)
+		ewmaHandler.mu.Unlock()
+	}
+
+	server, err := ewmaHandler.GetServer()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if server != fast {
+		t.Fatalf("Wrong Server detected: got %s, want %s", server.Url, fast.Url)
+	}
+
+	// Pending requests multiply the cost, the slow server wins once the fast
+	// one has enough of them queued
+	for range 5 {
+		ewmaHandler.GetServer()
+	}
+	server, _ = ewmaHandler.GetServer()
+	if server != slow {
+		t.Errorf("Wrong Server detected: got %s, want %s", server.Url, slow.Url)
+	}
+}
+
+func TestPeakEWMAPeakIsTakenImmediately(t *testing.T) {
+	ewmaHandler, clock := newPeakEWMATestHandler()
+	fast, slow := ewmaHandler.Servers[0], ewmaHandler.Servers[1]
+
+	ewmaHandler.mu.Lock()
+	ewmaHandler.observe(fast, 200*time.Millisecond, *clock)
+	ewmaHandler.observe(slow, 500*time.Millisecond, *clock)
+	ewmaHandler.mu.Unlock()
+
+	server, _ := ewmaHandler.GetServer()
+	ewmaHandler.Release(server, 3*time.Second, nil)
+	if got := time.Duration(ewmaHandler.stats[fast].cost); got != 3*time.Second {
+		t.Fatalf("Wrong cost after peak: got %s, want %s", got, 3*time.Second)
+	}
+
+	server, _ = ewmaHandler.GetServer()
+	if server != slow {
+		t.Errorf("Wrong Server detected: got %s, want %s", server.Url, slow.Url)
+	}
+	ewmaHandler.Release(server, 500*time.Millisecond, nil)
+
+	// The peak decays back below the slow server over time
+	*clock = clock.Add(30 * time.Second)
+	ewmaHandler.mu.Lock()
+	ewmaHandler.observe(slow, 500*time.Millisecond, *clock)
+	ewmaHandler.mu.Unlock()
+
+	server, _ = ewmaHandler.GetServer()
+	if server != fast {
+		t.Errorf("Wrong Server detected after decay: got %s, want %s", server.Url, fast.Url)
+	}
+}
+
+func TestPeakEWMAOnlyPenalizesBackendFailures(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "failing", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	testCases := []struct {
+		name    string
+		url     string
+		retry   config.RetryConfig
+		request func(handler *Handler)
+	}{
+		{
+			name:    "CaseClientTimeout",
+			url:     newHangingBackend(t).URL,
+			request: func(handler *Handler) { sendCanceled(handler, 1) },
+		},
+		{
+			name:  "CaseRetriedStatus",
+			url:   failing.URL,
+			retry: config.RetryConfig{MaxAttempts: 2, Statuses: []int{http.StatusServiceUnavailable}},
+			request: func(handler *Handler) {
+				serve(handler, httptest.NewRequest(http.MethodGet, "/", nil))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler, err := NewHandler("PeakEWMA", []Server{
+				{ServerConfig: config.ServerConfig{Url: tc.url}, IsAlive: true},
+				{ServerConfig: config.ServerConfig{Url: newNamedBackend(t, "backend").URL}, IsAlive: true},
+			}, &config.Config{Retry: tc.retry})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			handler.Logger = log.New(io.Discard, "", 0)
+
+			tc.request(handler)
+
+			ewmaHandler := handler.Strategy().(*PeakEWMAHandler)
+			ewmaHandler.mu.Lock()
+			defer ewmaHandler.mu.Unlock()
+			if got := time.Duration(ewmaHandler.stats[handler.Servers[0]].cost); got >= ewmaFailureLatency {
+				t.Errorf("Wrong cost of %s: got %s, want less than %s", tc.url, got, ewmaFailureLatency)
+			}
+		})
+	}
+}
+
+func TestPeakEWMAFailureIsPenalized(t *testing.T) {
+	ewmaHandler, _ := newPeakEWMATestHandler()
+	fast := ewmaHandler.Servers[0]
+
+	server, _ := ewmaHandler.GetServer()
+	if server != fast {
+		t.Fatalf("Wrong Server detected: got %s, want %s", server.Url, fast.Url)
+	}
+	ewmaHandler.Release(server, time.Millisecond, errors.New("connection refused"))
+
+	if got := time.Duration(ewmaHandler.stats[fast].cost); got != ewmaFailureLatency {
+		t.Errorf("Wrong cost after failure: got %s, want %s", got, ewmaFailureLatency)
+	}
+	if fast.InFlight != 0 {
+		t.Errorf("Wrong InFlight after release: got %d, want 0", fast.InFlight)
+	}
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"fmt"
+	"slices"
+)
+
+type priorityFailover struct {
+	minHealthyPercent uint
+}
+
+// EnablePriorityFailover groups servers into tiers by Priority, lowest value
+// first. Traffic only goes to the first tier whose healthy weight is at least
+// minHealthyPercent of its total weight. A degraded tier keeps serving with
+// its healthy servers while the next tiers are added, until one of them is
+// healthy enough. With minHealthyPercent 0 any healthy server keeps a tier in
+// charge. Until this is called all servers are treated as one tier.
+func (h *Handler) EnablePriorityFailover(minHealthyPercent int) error {
+	if minHealthyPercent < 0 || minHealthyPercent > 100 {
+		return fmt.Errorf("min healthy percent must be between 0 and 100, got %d", minHealthyPercent)
+	}
+
+	h.mu.Lock()
+	h.failover = &priorityFailover{minHealthyPercent: uint(minHealthyPercent)}
+	changed := h.updateTiers()
+	h.mu.Unlock()
+
+	if changed {
+		h.updateMembership()
+	}
+
+	return nil
+}
+
+// updateTiers puts the servers of inactive tiers on standby and reports
+// whether any changed. Callers hold h.mu.
+func (h *Handler) updateTiers() bool {
+	if h.failover == nil {
+		return false
+	}
+
+	var priorities []int
+	for _, server := range h.Servers {
+		priorities = append(priorities, server.Priority)
+	}
+	slices.Sort(priorities)
+	priorities = slices.Compact(priorities)
+
+	changed := false
+	active := true
+	for _, priority := range priorities {
+		var totalWeight, healthyWeight uint
+		for _, server := range h.Servers {
+			if server.Priority != priority {
+				continue
+			}
+			if server.standby == active {
+				server.standby = !active
+				changed = true
+			}
+			weight := max(server.Weight, 1)
+			totalWeight += weight
+			if server.healthy() {
+				healthyWeight += weight
+			}
+		}
+
+		if active && healthyWeight > 0 && healthyWeight*100 >= totalWeight*h.failover.minHealthyPercent {
+			active = false
+		}
+	}
+
+	if changed {
+		h.generation.Add(1)
+	}
+	return changed
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"emaiorov/load-balancer/config"
+	"testing"
+)
+
+func newTieredServers() []Server {
+	return []Server{
+		{ServerConfig: config.ServerConfig{Url: "http://primary1", Weight: 1, Priority: 0}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: "http://primary2", Weight: 1, Priority: 0}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: "http://backup", Weight: 1, Priority: 1}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: "http://dr", Weight: 1, Priority: 2}, IsAlive: true},
+	}
+}
+
+func TestPriorityFailoverTiers(t *testing.T) {
+	testCases := []struct {
+		name              string
+		minHealthyPercent int
+		dead              []int
+		expectedAvailable []bool
+	}{
+		{
+			name:              "CaseHealthyPrimaryTakesAllTraffic",
+			minHealthyPercent: 50,
+			expectedAvailable: []bool{true, true, false, false},
+		},
+		{
+			name:              "CaseDegradedPrimaryAboveMinimum",
+			minHealthyPercent: 50,
+			dead:              []int{0},
+			expectedAvailable: []bool{false, true, false, false},
+		},
+		{
+			name:              "CaseDegradedPrimarySpillsToBackup",
+			minHealthyPercent: 100,
+			dead:              []int{0},
+			expectedAvailable: []bool{false, true, true, false},
+		},
+		{
+			name:              "CaseDeadPrimaryAndBackupUsesDr",
+			minHealthyPercent: 0,
+			dead:              []int{0, 1, 2},
+			expectedAvailable: []bool{false, false, false, true},
+		},
+		{
+			name:              "CaseAllTiersDegradedUsesEveryHealthyServer",
+			minHealthyPercent: 100,
+			dead:              []int{0, 2},
+			expectedAvailable: []bool{false, true, false, true},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			wrHandler := NewWeightedRandomHandler(newTieredServers())
+			if err := wrHandler.EnablePriorityFailover(tc.minHealthyPercent); err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+
+			for _, i := range tc.dead {
+				wrHandler.SetAlive(wrHandler.Servers[i], false)
+			}
+
+			for i, server := range wrHandler.Servers {
+				if server.Available() != tc.expectedAvailable[i] {
+					t.Errorf("Wrong availability of %s: got %v, want %v", server.Url, server.Available(), tc.expectedAvailable[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPriorityFailoverRecovers(t *testing.T) {
+	wrHandler := NewWeightedRandomHandler(newTieredServers())
+	wrHandler.EnablePriorityFailover(100)
+	primary1 := wrHandler.Servers[0]
+
+	wrHandler.SetAlive(primary1, false)
+	wrHandler.SetAlive(primary1, true)
+
+	for range 50 {
+		server, err := wrHandler.GetServer()
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if server.Priority != 0 {
+			t.Fatalf("Backup %s used while the primary tier is healthy", server.Url)
+		}
+	}
+}
+
+func TestEnablePriorityFailoverRejectsInvalidPercent(t *testing.T) {
+	var h Handler
+	if err := h.EnablePriorityFailover(101); err == nil {
+		t.Errorf("Expected error for min healthy percent above 100")
+	}
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"context"
+	"crypto/tls"
+	"emaiorov/load-balancer/config"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+)
+
+const (
+	defaultMaxIdleConnsPerHost   = 64
+	defaultIdleConnTimeout       = 90 * time.Second
+	defaultDialTimeout           = 5 * time.Second
+	defaultKeepAlive             = 30 * time.Second
+	defaultTLSHandshakeTimeout   = 10 * time.Second
+	defaultExpectContinueTimeout = time.Second
+)
+
+// NewTransport builds the transport shared by the proxies of all servers.
+// Unset values get defaults suited to a load balancer, most notably more
+// idle connections per backend than the two of http.DefaultTransport.
+func NewTransport(transportConfig config.TransportConfig) *http.Transport {
+	maxIdleConnsPerHost := transportConfig.MaxIdleConnsPerHost
+	if maxIdleConnsPerHost <= 0 {
+		maxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   secondsOr(float64(transportConfig.DialTimeoutSeconds), defaultDialTimeout),
+		KeepAlive: secondsOr(float64(transportConfig.KeepAliveSeconds), defaultKeepAlive),
+	}
+
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+		IdleConnTimeout:       secondsOr(float64(transportConfig.IdleConnTimeoutSeconds), defaultIdleConnTimeout),
+		TLSHandshakeTimeout:   secondsOr(float64(transportConfig.TLSHandshakeTimeoutSeconds), defaultTLSHandshakeTimeout),
+		ResponseHeaderTimeout: secondsOr(float64(transportConfig.ResponseHeaderTimeoutSeconds), 0),
+		ExpectContinueTimeout: defaultExpectContinueTimeout,
+		ForceAttemptHTTP2:     !transportConfig.DisableHTTP2,
+	}
+	if transportConfig.DisableHTTP2 {
+		// A non-nil empty map turns off the automatic HTTP/2 upgrade
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+
+	return transport
+}
+
+type proxyRequestKey struct{}
+
+// proxyRequest is the per-request state of the shared proxies. It is the
+// request context, so the proxy hooks can find it, and once the response
+// headers arrive it wraps the response body to report the result when the
+// body is closed. One allocation per request covers both.
+type proxyRequest struct {
+	context.Context
+	handler         *Handler
+	server          *Server
+	start           time.Time
+	body            io.ReadCloser
+	timeToFirstByte time.Duration
+	retry           *retryState
+}
+
+func (r *proxyRequest) Value(key any) any {
+	if key == (proxyRequestKey{}) {
+		return r
+	}
+	return r.Context.Value(key)
+}
+
+func (r *proxyRequest) Close() error {
+	r.handler.strategy.Done(r.server, Result{
+		TimeToFirstByte: r.timeToFirstByte,
+		Duration:        time.Since(r.start),
+	})
+	return r.body.Close()
+}
+
+func (r *proxyRequest) Read(p []byte) (n int, err error) {
+	return r.body.Read(p)
+}
+
+// clientGone reports whether the inbound request was canceled, so a proxy
+// error says nothing about the backend. The per-try timeout of a retried
+// request only cancels the attempt and still counts against the backend.
+func (r *proxyRequest) clientGone() bool {
+	if r.retry != nil {
+		return r.retry.request.Context().Err() != nil
+	}
+	return r.Err() != nil
+}
+
+// proxyFor returns the long-lived proxy of server, or nil when its url is
+// invalid. The proxies are built on first use so Transport and Logger can
+// be set after the handler is created.
+func (h *Handler) proxyFor(server *Server) *httputil.ReverseProxy {
+	h.proxiesOnce.Do(func() {
+		servers := h.serverList()
+		h.proxies = make(map[*Server]*httputil.ReverseProxy, len(servers))
+		for _, server := range servers {
+			targetUrl, err := url.Parse(server.Url)
+			if err != nil {
+				h.logf("ERROR: Could not parse server URL %s: %v", server.Url, err)
+				continue
+			}
+			h.proxies[server] = h.newProxy(targetUrl)
+		}
+	})
+	return h.proxies[server]
+}
+
+func (h *Handler) newProxy(targetUrl *url.URL) *httputil.ReverseProxy {
+	proxy := httputil.NewSingleHostReverseProxy(targetUrl)
+	proxy.Transport = h.Transport
+	proxy.ErrorLog = h.Logger
+
+	proxy.ModifyResponse = func(res *http.Response) error {
+		request := res.Request.Context().Value(proxyRequestKey{}).(*proxyRequest)
+		request.timeToFirstByte = time.Since(request.start)
+		h.observeOutcome(request.server, res.StatusCode >= http.StatusInternalServerError)
+		if request.retry != nil && request.retry.timer != nil {
+			request.retry.timer.Stop()
+		}
+		if h.retryable(request, res.StatusCode) && h.planRetry(request, true) {
+			return &retryStatusError{status: res.StatusCode}
+		}
+		request.body = res.Body
+		res.Body = request
+		return nil
+	}
+
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, e error) {
+		request := r.Context().Value(proxyRequestKey{}).(*proxyRequest)
+		h.logf("Proxy error to %s: %v", request.server.Url, e)
+		// A retried status is a response of the backend, not an error
+		var statusErr *retryStatusError
+		retried := errors.As(e, &statusErr)
+		canceled := !retried && request.clientGone()
+		result := Result{TimeToFirstByte: request.timeToFirstByte, Duration: time.Since(request.start), Canceled: canceled}
+		if !retried && !canceled {
+			result.Err = e
+		}
+		h.strategy.Done(request.server, result)
+
+		// A retried status was already observed and its retry planned
+		if retried {
+			return
+		}
+		if canceled {
+			h.releaseCircuitTrial(request.server)
+		} else {
+			h.observeOutcome(request.server, true)
+			if h.planRetry(request, !isConnectError(e)) {
+				return
+			}
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	return proxy
+}
+
+// forward proxies the request to server and reports the result to the
+// strategy once the response body is closed or the backend could not be
+// reached.
+func (h *Handler) forward(w http.ResponseWriter, r *http.Request, server *Server) {
+	if retry := h.newRetryState(r, server); retry != nil {
+		h.forwardWithRetries(w, r, server, retry)
+		return
+	}
+
+	proxy := h.proxyFor(server)
+	if proxy == nil {
+		h.strategy.Done(server, Result{Err: fmt.Errorf("invalid server url %s", server.Url)})
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	request := &proxyRequest{Context: r.Context(), handler: h, server: server, start: time.Now()}
+	proxy.ServeHTTP(w, r.WithContext(request))
+}
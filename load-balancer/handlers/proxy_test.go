@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"emaiorov/load-balancer/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestProxyIsReusedPerServer(t *testing.T) {
+	backend1 := newNamedBackend(t, "backend-1")
+	backend2 := newNamedBackend(t, "backend-2")
+	rrHandler := NewRoundRobinHandler([]Server{
+		{ServerConfig: config.ServerConfig{Url: backend1.URL}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: backend2.URL}, IsAlive: true},
+	})
+
+	proxies := map[*Server]*httputil.ReverseProxy{}
+	for range 4 {
+		sendWithCookie(rrHandler, nil)
+		for _, server := range rrHandler.Servers {
+			proxy := rrHandler.proxyFor(server)
+			if previous, ok := proxies[server]; ok && previous != proxy {
+				t.Errorf("Proxy of %s was rebuilt", server.Url)
+			}
+			proxies[server] = proxy
+		}
+	}
+
+	if len(proxies) != 2 || proxies[rrHandler.Servers[0]] == proxies[rrHandler.Servers[1]] {
+		t.Errorf("Servers do not have a proxy each")
+	}
+}
+
+func TestProxyInvalidUrl(t *testing.T) {
+	rrHandler := NewRoundRobinHandler([]Server{
+		{ServerConfig: config.ServerConfig{Url: "http://bad host"}, IsAlive: true},
+	})
+
+	w := httptest.NewRecorder()
+	rrHandler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("Wrong status for an invalid server url: got %d, want %d", w.Code, http.StatusBadGateway)
+	}
+}
+
+func TestNewTransport(t *testing.T) {
+	testCases := []struct {
+		name                          string
+		transportConfig               config.TransportConfig
+		expectedMaxIdleConnsPerHost   int
+		expectedIdleConnTimeout       time.Duration
+		expectedResponseHeaderTimeout time.Duration
+		expectedHTTP2                 bool
+	}{
+		{
+			name:                        "CaseDefaults",
+			expectedMaxIdleConnsPerHost: defaultMaxIdleConnsPerHost,
+			expectedIdleConnTimeout:     defaultIdleConnTimeout,
+			expectedHTTP2:               true,
+		},
+		{
+			name: "CaseConfigured",
+			transportConfig: config.TransportConfig{
+				MaxIdleConnsPerHost:          8,
+				IdleConnTimeoutSeconds:       30,
+				ResponseHeaderTimeoutSeconds: 2,
+				DisableHTTP2:                 true,
+			},
+			expectedMaxIdleConnsPerHost:   8,
+			expectedIdleConnTimeout:       30 * time.Second,
+			expectedResponseHeaderTimeout: 2 * time.Second,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			transport := NewTransport(tc.transportConfig)
+
+			if transport.MaxIdleConnsPerHost != tc.expectedMaxIdleConnsPerHost {
+				t.Errorf("Wrong MaxIdleConnsPerHost: got %d, want %d", transport.MaxIdleConnsPerHost, tc.expectedMaxIdleConnsPerHost)
+			}
+			if transport.IdleConnTimeout != tc.expectedIdleConnTimeout {
+				t.Errorf("Wrong IdleConnTimeout: got %v, want %v", transport.IdleConnTimeout, tc.expectedIdleConnTimeout)
+			}
+			if transport.ResponseHeaderTimeout != tc.expectedResponseHeaderTimeout {
+				t.Errorf("Wrong ResponseHeaderTimeout: got %v, want %v", transport.ResponseHeaderTimeout, tc.expectedResponseHeaderTimeout)
+			}
+			http2 := transport.ForceAttemptHTTP2 && transport.TLSNextProto == nil
+			if http2 != tc.expectedHTTP2 {
+				t.Errorf("Wrong HTTP/2: got %v, want %v", http2, tc.expectedHTTP2)
+			}
+		})
+	}
+}
+
+// BenchmarkServeHTTPReusedProxy goes through the shared per-server proxy and
+// tuned transport. Compare with BenchmarkServeHTTPProxyPerRequest:
+//
+//	go test ./handlers -run '^$' -bench ServeHTTP -benchmem -cpu 1,8
+func BenchmarkServeHTTPReusedProxy(b *testing.B) {
+	backend := newNamedBackend(b, "backend")
+	lcHandler := NewLeastConnectionsHandler([]Server{
+		{ServerConfig: config.ServerConfig{Url: backend.URL}, IsAlive: true},
+	})
+	lcHandler.Transport = NewTransport(config.TransportConfig{})
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		for pb.Next() {
+			lcHandler.ServeHTTP(httptest.NewRecorder(), req)
+		}
+	})
+}
+
+// BenchmarkServeHTTPProxyPerRequest parses the url and builds the proxy on
+// every request over http.DefaultTransport, as the handlers used to.
+func BenchmarkServeHTTPProxyPerRequest(b *testing.B) {
+	backend := newNamedBackend(b, "backend")
+	lcHandler := NewLeastConnectionsHandler([]Server{
+		{ServerConfig: config.ServerConfig{Url: backend.URL}, IsAlive: true},
+	})
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		for pb.Next() {
+			server, _ := lcHandler.GetServer()
+			targetUrl, _ := url.Parse(server.Url)
+			proxy := httputil.NewSingleHostReverseProxy(targetUrl)
+			start := time.Now()
+			proxy.ModifyResponse = func(res *http.Response) error {
+				timeToFirstByte := time.Since(start)
+				res.Body = &closeFunc{ReadCloser: res.Body, close: func() {
+					lcHandler.Done(server, Result{TimeToFirstByte: timeToFirstByte, Duration: time.Since(start)})
+				}}
+				return nil
+			}
+			proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, e error) {
+				lcHandler.Done(server, Result{Err: e})
+				w.WriteHeader(http.StatusBadGateway)
+			}
+			proxy.ServeHTTP(httptest.NewRecorder(), req)
+		}
+	})
+}
+
+type closeFunc struct {
+	io.ReadCloser
+	close func()
+}
+
+func (c *closeFunc) Close() error {
+	c.close()
+	return c.ReadCloser.Close()
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"emaiorov/load-balancer/config"
+	"fmt"
+	"math"
+	"net/http"
+)
+
+// RendezvousHandler implements weighted highest random weight hashing. Each
+// live server scores -Weight/ln(h) for a uniform hash h of (server, key) and
+// the highest score wins, so a server going down only moves its own keys.
+type RendezvousHandler struct {
+	Handler
+	key KeyFunc
+}
+
+func NewRendezvousHandler(servers []Server, hashConfig config.HashConfig) (*RendezvousHandler, error) {
+	return newRendezvousHandler(serverPointers(servers), hashConfig)
+}
+
+func newRendezvousHandler(serversPtrs []*Server, hashConfig config.HashConfig) (*RendezvousHandler, error) {
+	key, err := NewKeyFunc(hashConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, server := range serversPtrs {
+		if server.Weight == 0 {
+			server.Weight = 1
+		}
+	}
+
+	handler := &RendezvousHandler{
+		Handler: Handler{
+			Servers: serversPtrs,
+		},
+		key: key,
+	}
+	handler.strategy = handler
+
+	return handler, nil
+}
+
+// rendezvousScore returns the weighted HRW score of the server for key.
+func rendezvousScore(server *Server, key string) float64 {
+	hash := mix64(hashString(key, server.Url))
+	// Map to (0, 1) using the top 53 bits so ln never sees 0 or 1
+	unit := (float64(hash>>11) + 0.5) / (1 << 53)
+	return -float64(server.Weight) / math.Log(unit)
+}
+
+// mix64 is the splitmix64 finalizer, spreading fnv output over all bits.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func (h *RendezvousHandler) GetServer(r *http.Request) (*Server, error) {
+	key := h.key(r)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var best *Server
+	var bestScore float64
+	for _, server := range h.Servers {
+		if !server.Available() {
+			continue
+		}
+		score := rendezvousScore(server, key)
+		if best == nil || score > bestScore {
+			best = server
+			bestScore = score
+		}
+	}
+
+	if best == nil {
+		return &Server{}, fmt.Errorf("no active destinations")
+	}
+
+	return best, nil
+}
+
+func (h *RendezvousHandler) Pick(r *http.Request) (*Server, error) {
+	return h.GetServer(r)
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"emaiorov/load-balancer/config"
+	"fmt"
+	"testing"
+)
+
+func TestRendezvousWeightedDistribution(t *testing.T) {
+	servers := []Server{
+		{ServerConfig: config.ServerConfig{Url: "http://s1", Weight: 1}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: "http://s2", Weight: 3}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: "http://s3", Weight: 0}, IsAlive: true},
+	}
+	hrwHandler, err := NewRendezvousHandler(servers, config.HashConfig{Key: "header", Name: "X-Key"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	counts := map[string]int{}
+	for i := range 10000 {
+		server, err := hrwHandler.GetServer(hashRequest(fmt.Sprintf("key-%d", i)))
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		counts[server.Url]++
+	}
+
+	// Expect roughly a 1:3:1 split
+	if counts["http://s2"] < 5500 || counts["http://s2"] > 6500 {
+		t.Errorf("Unexpected distribution: %v", counts)
+	}
+	if counts["http://s1"] < 1500 || counts["http://s3"] < 1500 {
+		t.Errorf("Unexpected distribution: %v", counts)
+	}
+}
+
+func TestRendezvousOnlyFailedServerKeysMove(t *testing.T) {
+	servers := []Server{
+		{ServerConfig: config.ServerConfig{Url: "http://s1", Weight: 1}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: "http://s2", Weight: 2}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: "http://s3", Weight: 1}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: "http://s4", Weight: 1}, IsAlive: true},
+	}
+	hrwHandler, _ := NewRendezvousHandler(servers, config.HashConfig{Key: "header", Name: "X-Key"})
+
+	before := map[string]*Server{}
+	for i := range 2000 {
+		key := fmt.Sprintf("key-%d", i)
+		before[key], _ = hrwHandler.GetServer(hashRequest(key))
+	}
+
+	down := hrwHandler.Servers[1]
+	hrwHandler.SetAlive(down, false)
+
+	for key, previous := range before {
+		server, _ := hrwHandler.GetServer(hashRequest(key))
+		if server == down {
+			t.Fatalf("Key %s routed to dead server", key)
+		}
+		if previous != down && server != previous {
+			t.Errorf("Key %s moved from %s to %s", key, previous.Url, server.Url)
+		}
+	}
+}
+
+func TestRendezvousAllServersDown(t *testing.T) {
+	servers := []Server{
+		{ServerConfig: config.ServerConfig{Url: "http://s1"}, IsAlive: false},
+	}
+	hrwHandler, _ := NewRendezvousHandler(servers, config.HashConfig{})
+
+	if _, err := hrwHandler.GetServer(hashRequest("key")); err == nil {
+		t.Errorf("Expected error that no servers found")
+	}
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"emaiorov/load-balancer/config"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultRetryBackoff      = 25 * time.Millisecond
+	defaultRetryMaxBackoff   = 250 * time.Millisecond
+	defaultRetryMaxBodyBytes = 64 << 10
+)
+
+type retryPolicy struct {
+	maxAttempts   int
+	statuses      []int
+	perTryTimeout time.Duration
+	backoff       time.Duration
+	maxBackoff    time.Duration
+	maxBodyBytes  int64
+	budget        *retryBudget
+	retried       atomic.Uint64
+	exhausted     atomic.Uint64
+}
+
+// RetryStats counts the retries of a Handler.
+type RetryStats struct {
+	Retries         uint64 `json:"retries"`
+	BudgetExhausted uint64 `json:"budget_exhausted"`
+}
+
+// retryState follows a request that may be sent to several servers.
+type retryState struct {
+	request    *http.Request
+	body       []byte
+	idempotent bool
+	attempt    int
+	tried      []*Server
+	// next is set by the proxy hooks when the attempt failed and is retried
+	next  *Server
+	timer *time.Timer
+}
+
+// retryStatusError replaces a response with a retried status code, so the
+// proxy discards it instead of sending it to the client.
+type retryStatusError struct {
+	status int
+}
+
+func (e *retryStatusError) Error() string {
+	return fmt.Sprintf("retried status %d", e.status)
+}
+
+// EnableRetries sends requests failing on one server again to another. It
+// must be called before the first request.
+func (h *Handler) EnableRetries(retryConfig config.RetryConfig) error {
+	if retryConfig.MaxAttempts < 1 {
+		return fmt.Errorf("max attempts must be at least 1, got %d", retryConfig.MaxAttempts)
+	}
+	if retryConfig.PerTryTimeoutSeconds < 0 || retryConfig.BackoffSeconds < 0 ||
+		retryConfig.MaxBackoffSeconds < 0 || retryConfig.MaxBodyBytes < 0 ||
+		retryConfig.BudgetPercent < 0 || retryConfig.MinRetriesPerSecond < 0 || retryConfig.BudgetWindowSeconds < 0 {
+		return fmt.Errorf("retry settings must not be negative")
+	}
+	for _, status := range retryConfig.Statuses {
+		if status < 100 || status > 599 {
+			return fmt.Errorf("invalid retry status %d", status)
+		}
+	}
+
+	retries := &retryPolicy{
+		maxAttempts:   retryConfig.MaxAttempts,
+		statuses:      retryConfig.Statuses,
+		perTryTimeout: secondsOr(retryConfig.PerTryTimeoutSeconds, 0),
+		backoff:       secondsOr(retryConfig.BackoffSeconds, defaultRetryBackoff),
+		maxBackoff:    secondsOr(retryConfig.MaxBackoffSeconds, defaultRetryMaxBackoff),
+		maxBodyBytes:  retryConfig.MaxBodyBytes,
+	}
+	if retries.maxBodyBytes == 0 {
+		retries.maxBodyBytes = defaultRetryMaxBodyBytes
+	}
+	retries.maxBackoff = max(retries.maxBackoff, retries.backoff)
+
+	budgetPercent := retryConfig.BudgetPercent
+	if budgetPercent == 0 {
+		budgetPercent = defaultRetryBudgetPercent
+	}
+	minPerSecond := retryConfig.MinRetriesPerSecond
+	if minPerSecond == 0 {
+		minPerSecond = defaultRetryBudgetMinPerSecond
+	}
+	windowSeconds := retryConfig.BudgetWindowSeconds
+	if windowSeconds == 0 {
+		windowSeconds = defaultRetryBudgetWindowSeconds
+	}
+	retries.budget = newRetryBudget(budgetPercent, minPerSecond, windowSeconds)
+
+	h.mu.Lock()
+	h.retries = retries
+	h.mu.Unlock()
+
+	return nil
+}
+
+// newRetryState buffers the body of r so it can be sent again, and returns
+// nil when r cannot be retried.
+func (h *Handler) newRetryState(r *http.Request, server *Server) *retryState {
+	p := h.retries
+	if p == nil || p.maxAttempts < 2 {
+		return nil
+	}
+	p.budget.deposit()
+
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		if r.ContentLength > p.maxBodyBytes {
+			return nil
+		}
+		buffered, err := io.ReadAll(io.LimitReader(r.Body, p.maxBodyBytes+1))
+		if err != nil || int64(len(buffered)) > p.maxBodyBytes {
+			// Send what was read followed by the rest, without retries
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(buffered), r.Body), r.Body}
+			return nil
+		}
+		body = buffered
+	}
+
+	return &retryState{
+		request:    r,
+		body:       body,
+		idempotent: isIdempotent(r.Method),
+		tried:      []*Server{server},
+	}
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// isConnectError reports whether err happened before the request reached
+// the backend, so any request can be sent again.
+func isConnectError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// retryable reports whether a response with status should be retried.
+func (h *Handler) retryable(request *proxyRequest, status int) bool {
+	return request.retry != nil && request.retry.idempotent && slices.Contains(h.retries.statuses, status)
+}
+
+// planRetry picks the server for the next attempt of a failed request and
+// reports whether there is one. sent tells whether the failed attempt may
+// have reached the backend.
+func (h *Handler) planRetry(request *proxyRequest, sent bool) bool {
+	retry := request.retry
+	if retry == nil || retry.attempt >= h.retries.maxAttempts || retry.request.Context().Err() != nil {
+		return false
+	}
+	if sent && !retry.idempotent {
+		return false
+	}
+	if !h.retries.budget.withdraw() {
+		h.retries.exhausted.Add(1)
+		return false
+	}
+
+	next, err := h.pickExcept(retry.request, retry.tried)
+	if err != nil {
+		h.retries.budget.refund()
+		return false
+	}
+	retry.next = next
+	h.retries.retried.Add(1)
+	return true
+}
+
+// RetryStats returns the number of retries and of retries denied by the
+// retry budget, or nil without retries.
+func (h *Handler) RetryStats() *RetryStats {
+	if h.retries == nil {
+		return nil
+	}
+	return &RetryStats{
+		Retries:         h.retries.retried.Load(),
+		BudgetExhausted: h.retries.exhausted.Load(),
+	}
+}
+
+// forwardWithRetries proxies the request, sending it again to other servers
+// while attempts fail.
+func (h *Handler) forwardWithRetries(w http.ResponseWriter, r *http.Request, server *Server, retry *retryState) {
+	for {
+		retry.attempt++
+		retry.next = nil
+
+		proxy := h.proxyFor(server)
+		if proxy == nil {
+			h.strategy.Done(server, Result{Err: fmt.Errorf("invalid server url %s", server.Url)})
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		ctx, cancel := context.WithCancel(r.Context())
+		if h.retries.perTryTimeout > 0 {
+			retry.timer = time.AfterFunc(h.retries.perTryTimeout, cancel)
+		}
+		request := &proxyRequest{Context: ctx, handler: h, server: server, start: time.Now(), retry: retry}
+		outreq := r.WithContext(request)
+		if retry.body != nil {
+			outreq.Body = io.NopCloser(bytes.NewReader(retry.body))
+		}
+		proxy.ServeHTTP(w, outreq)
+		if retry.timer != nil {
+			retry.timer.Stop()
+		}
+		cancel()
+
+		if retry.next == nil {
+			return
+		}
+		server = retry.next
+		retry.tried = append(retry.tried, server)
+
+		if !h.retries.wait(r.Context(), retry.attempt) {
+			h.strategy.Done(server, Result{Skipped: true})
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		h.resetStickyCookie(w, server)
+	}
+}
+
+// wait sleeps for a random backoff doubling with each attempt, and reports
+// false when ctx is done first.
+func (p *retryPolicy) wait(ctx context.Context, attempt int) bool {
+	backoff := p.backoff
+	for i := 1; i < attempt && backoff < p.maxBackoff; i++ {
+		backoff *= 2
+	}
+	backoff = time.Duration(rand.Float64() * float64(min(backoff, p.maxBackoff)))
+
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultRetryBudgetPercent       = 20
+	defaultRetryBudgetMinPerSecond  = 10
+	defaultRetryBudgetWindowSeconds = 10
+)
+
+// retryBudget is a token bucket limiting retries to a share of the traffic.
+// Every request deposits ratio tokens, minPerSecond tokens are added every
+// second, tokens expire after the window and every retry withdraws one. It
+// counts requests and retries in one slot per second of the window.
+type retryBudget struct {
+	ratio        float64
+	minPerSecond float64
+	now          func() time.Time
+
+	mu    sync.Mutex
+	slots []budgetSlot
+}
+
+type budgetSlot struct {
+	second   int64
+	requests int
+	retries  int
+}
+
+func newRetryBudget(percent float64, minPerSecond float64, windowSeconds int) *retryBudget {
+	return &retryBudget{
+		ratio:        percent / 100,
+		minPerSecond: minPerSecond,
+		now:          time.Now,
+		slots:        make([]budgetSlot, windowSeconds),
+	}
+}
+
+// slot returns the slot of the current second. Callers hold b.mu.
+func (b *retryBudget) slot(second int64) *budgetSlot {
+	slot := &b.slots[second%int64(len(b.slots))]
+	if slot.second != second {
+		*slot = budgetSlot{second: second}
+	}
+	return slot
+}
+
+// deposit records a request.
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.slot(b.now().Unix()).requests++
+}
+
+// withdraw records a retry and reports whether the budget allowed it.
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	second := b.now().Unix()
+	window := int64(len(b.slots))
+	var requests, retries int
+	for _, slot := range b.slots {
+		if slot.second > second-window {
+			requests += slot.requests
+			retries += slot.retries
+		}
+	}
+
+	balance := float64(requests)*b.ratio + b.minPerSecond*float64(window) - float64(retries)
+	if balance < 1 {
+		return false
+	}
+	b.slot(second).retries++
+	return true
+}
+
+// refund returns a retry that was not sent.
+func (b *retryBudget) refund() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if slot := b.slot(b.now().Unix()); slot.retries > 0 {
+		slot.retries--
+	}
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"emaiorov/load-balancer/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRetryBudget(t *testing.T) {
+	clock := time.Unix(1000, 0)
+	budget := newRetryBudget(20, 1, 10)
+	budget.now = func() time.Time { return clock }
+
+	withdrawals := func() int {
+		count := 0
+		for budget.withdraw() {
+			count++
+		}
+		return count
+	}
+
+	// The floor alone allows one retry a second over the window
+	if count := withdrawals(); count != 10 {
+		t.Errorf("Wrong number of retries without requests: got %d, want 10", count)
+	}
+
+	for range 100 {
+		budget.deposit()
+	}
+	if count := withdrawals(); count != 20 {
+		t.Errorf("Wrong number of retries after 100 requests: got %d, want 20", count)
+	}
+
+	budget.refund()
+	if count := withdrawals(); count != 1 {
+		t.Errorf("Wrong number of retries after a refund: got %d, want 1", count)
+	}
+
+	clock = clock.Add(10 * time.Second)
+	if count := withdrawals(); count != 10 {
+		t.Errorf("Wrong number of retries once the window passed: got %d, want 10", count)
+	}
+}
+
+func TestRetryBudgetExhausted(t *testing.T) {
+	handler := newRetryTestHandler(t, config.RetryConfig{
+		MaxAttempts:         2,
+		Statuses:            []int{http.StatusServiceUnavailable},
+		BudgetPercent:       1,
+		MinRetriesPerSecond: 0.1,
+	}, 2, http.StatusServiceUnavailable)
+
+	for range 10 {
+		serve(handler, httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+
+	stats := handler.RetryStats()
+	if stats.Retries != 1 || stats.BudgetExhausted != 9 {
+		t.Errorf("Wrong retry stats: got %+v, want 1 retry and 9 exhausted", *stats)
+	}
+
+	if stats := (&Handler{}).RetryStats(); stats != nil {
+		t.Errorf("Wrong stats without retries: got %+v, want nil", *stats)
+	}
+}
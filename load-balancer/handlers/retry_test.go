@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"emaiorov/load-balancer/config"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newRetryTestHandler balances over urls followed by failing backends
+// answering with status.
+func newRetryTestHandler(t *testing.T, retryConfig config.RetryConfig, failing int, status int, urls ...string) *Handler {
+	for range failing {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, http.StatusText(status), status)
+		}))
+		t.Cleanup(backend.Close)
+		urls = append(urls, backend.URL)
+	}
+
+	var servers []Server
+	for _, url := range urls {
+		servers = append(servers, Server{ServerConfig: config.ServerConfig{Url: url}, IsAlive: true})
+	}
+	handler, err := NewHandler("RoundRobin", servers, &config.Config{Retry: retryConfig})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	return handler
+}
+
+func TestRetry(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	downUrl := down.URL
+	down.Close()
+
+	testCases := []struct {
+		name           string
+		method         string
+		failingStatus  int
+		maxBodyBytes   int64
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "CaseConnectionRefusedGet",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "backend",
+		},
+		{
+			name:           "CaseConnectionRefusedPostKeepsBody",
+			method:         http.MethodPost,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "backendpayload",
+		},
+		{
+			name:           "CaseConnectionRefusedBodyTooLarge",
+			method:         http.MethodPut,
+			maxBodyBytes:   4,
+			expectedStatus: http.StatusBadGateway,
+		},
+		{
+			name:           "CaseRetriedStatus",
+			method:         http.MethodPut,
+			failingStatus:  http.StatusServiceUnavailable,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "backendpayload",
+		},
+		{
+			name:           "CaseRetriedStatusNotIdempotent",
+			method:         http.MethodPost,
+			failingStatus:  http.StatusServiceUnavailable,
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:           "CaseOtherStatus",
+			method:         http.MethodGet,
+			failingStatus:  http.StatusInternalServerError,
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			backend := newNamedBackend(t, "backend")
+			retryConfig := config.RetryConfig{
+				MaxAttempts:  2,
+				Statuses:     []int{http.StatusBadGateway, http.StatusServiceUnavailable},
+				MaxBodyBytes: tc.maxBodyBytes,
+			}
+			var handler *Handler
+			if tc.failingStatus != 0 {
+				handler = newRetryTestHandler(t, retryConfig, 1, tc.failingStatus, backend.URL)
+			} else {
+				handler = newRetryTestHandler(t, retryConfig, 0, 0, downUrl, backend.URL)
+			}
+
+			// Round robin goes to the failing server next
+			for handler.pickUrl(t, httptest.NewRequest(http.MethodGet, "/", nil)) != backend.URL {
+			}
+
+			payload := "payload"
+			if tc.method == http.MethodGet {
+				payload = ""
+			}
+			resp, body := serve(handler, httptest.NewRequest(tc.method, "/", strings.NewReader(payload)))
+			if resp.StatusCode != tc.expectedStatus {
+				t.Errorf("Wrong status: got %d, want %d", resp.StatusCode, tc.expectedStatus)
+			}
+			if tc.expectedBody != "" && body != tc.expectedBody {
+				t.Errorf("Wrong body: got %q, want %q", body, tc.expectedBody)
+			}
+		})
+	}
+}
+
+// pickUrl returns the url of the server picked for r, and releases it.
+func (h *Handler) pickUrl(t *testing.T, r *http.Request) string {
+	server, err := h.pick(r)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	h.strategy.Done(server, Result{Skipped: true})
+	return server.Url
+}
+
+func TestRetryMaxAttempts(t *testing.T) {
+	handler := newRetryTestHandler(t, config.RetryConfig{
+		MaxAttempts: 2,
+		Statuses:    []int{http.StatusServiceUnavailable},
+	}, 3, http.StatusServiceUnavailable)
+
+	resp, body := serve(handler, httptest.NewRequest(http.MethodGet, "/", nil))
+	if resp.StatusCode != http.StatusServiceUnavailable || !strings.Contains(body, "Service Unavailable") {
+		t.Errorf("Wrong response of the last attempt: got %d %q", resp.StatusCode, body)
+	}
+	if retries := handler.RetryStats().Retries; retries != 1 {
+		t.Errorf("Wrong number of retries: got %d, want 1", retries)
+	}
+}
+
+func TestRetryPerTryTimeout(t *testing.T) {
+	slow := newHangingBackend(t)
+	backend := newNamedBackend(t, "backend")
+
+	handler := newRetryTestHandler(t, config.RetryConfig{
+		MaxAttempts:          2,
+		PerTryTimeoutSeconds: 0.05,
+	}, 0, 0, slow.URL, backend.URL)
+
+	start := time.Now()
+	resp, body := serve(handler, httptest.NewRequest(http.MethodGet, "/", nil))
+	if resp.StatusCode != http.StatusOK || body != "backend" {
+		t.Errorf("Wrong response: got %d %q", resp.StatusCode, body)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Per try timeout not applied: took %v", elapsed)
+	}
+}
+
+func TestRetryHashStrategy(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	down.Close()
+	backend := newNamedBackend(t, "backend")
+
+	handler, err := NewHandler("ConsistentHash", []Server{
+		{ServerConfig: config.ServerConfig{Url: down.URL}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: backend.URL}, IsAlive: true},
+	}, &config.Config{
+		Hash:  config.HashConfig{Key: "header", Name: "X-Key"},
+		Retry: config.RetryConfig{MaxAttempts: 2},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	// Find a key owned by the server that is down, which the strategy keeps
+	// picking
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for i := 0; handler.pickUrl(t, req) != down.URL; i++ {
+		req.Header.Set("X-Key", strconv.Itoa(i))
+	}
+
+	if resp, body := serve(handler, req); resp.StatusCode != http.StatusOK || body != "backend" {
+		t.Errorf("Wrong response: got %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestEnableRetriesErrors(t *testing.T) {
+	for _, retryConfig := range []config.RetryConfig{
+		{MaxAttempts: 0},
+		{MaxAttempts: 2, BackoffSeconds: -1},
+		{MaxAttempts: 2, Statuses: []int{1000}},
+	} {
+		h := &Handler{}
+		if err := h.EnableRetries(retryConfig); err == nil {
+			t.Errorf("Expected error for %+v", retryConfig)
+		}
+	}
+}
@@ -3,10 +3,7 @@ package handlers
 import (
 	"cmp"
 	"fmt"
-	"log"
 	"net/http"
-	"net/http/httputil"
-	"net/url"
 	"slices"
 )
 
@@ -15,17 +12,16 @@ type RoundRobinHandler struct {
 }
 
 func NewRoundRobinHandler(servers []Server) *RoundRobinHandler {
-	serversPtrs := make([]*Server, len(servers))
-
-	for i := range servers {
-		serversPtrs[i] = &servers[i]
-	}
+	return newRoundRobinHandler(serverPointers(servers))
+}
 
+func newRoundRobinHandler(serversPtrs []*Server) *RoundRobinHandler {
 	handler := &RoundRobinHandler{
 		Handler: Handler{
 			Servers: serversPtrs,
 		},
 	}
+	handler.strategy = handler
 
 	// Add initial sorting for servers accordingly
 	slices.SortFunc(handler.Servers, func(a, b *Server) int {
@@ -36,6 +32,14 @@ func NewRoundRobinHandler(servers []Server) *RoundRobinHandler {
 }
 
 func (h *RoundRobinHandler) GetUrl() (string, error) {
+	server, err := h.GetServer()
+	if err != nil {
+		return "", err
+	}
+	return server.Url, nil
+}
+
+func (h *RoundRobinHandler) GetServer() (*Server, error) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
@@ -43,33 +47,18 @@ func (h *RoundRobinHandler) GetUrl() (string, error) {
 	counter := h.GetCounter()
 	for range serverCounter {
 		server := h.Servers[counter.index]
-		if server.IsAlive {
-			url := server.Url
+		if server.Available() {
 			if server.Counter.NextAndWrap() {
 				counter.Next()
 			}
-			return url, nil
+			return server, nil
 		}
 		counter.Next()
 	}
 
-	return "", fmt.Errorf("no active destinations")
+	return &Server{}, fmt.Errorf("no active destinations")
 }
 
-func (handler *RoundRobinHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-
-	destination, err := handler.GetUrl()
-
-	if err != nil {
-		w.WriteHeader(int(http.StatusServiceUnavailable))
-		fmt.Fprintf(w, "All servers failed on health check")
-		return
-	}
-
-	targetUrl, err := url.Parse(destination)
-	if err != nil {
-		log.Fatal(err)
-	}
-	proxy := httputil.NewSingleHostReverseProxy(targetUrl)
-	proxy.ServeHTTP(w, r)
+func (h *RoundRobinHandler) Pick(r *http.Request) (*Server, error) {
+	return h.GetServer()
 }
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"emaiorov/load-balancer/config"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultSlowStartAggression       = 1
+	defaultSlowStartMinWeightPercent = 10
+)
+
+type slowStart struct {
+	window     time.Duration
+	aggression float64
+	minWeight  float64
+}
+
+// EnableSlowStart ramps up the traffic to servers whose health checks pass
+// again, so they are not flooded while cold. While ramping up, a server
+// picked by the strategy is only used in proportion to its effective weight
+// and the strategy is asked again otherwise, which works with every
+// strategy. It must be called before the first request.
+func (h *Handler) EnableSlowStart(slowStartConfig config.SlowStartConfig) error {
+	if slowStartConfig.WindowSeconds <= 0 {
+		return fmt.Errorf("slow start window must be positive, got %v", slowStartConfig.WindowSeconds)
+	}
+	if slowStartConfig.Aggression < 0 {
+		return fmt.Errorf("slow start aggression must not be negative, got %v", slowStartConfig.Aggression)
+	}
+	if slowStartConfig.MinWeightPercent < 0 || slowStartConfig.MinWeightPercent > 100 {
+		return fmt.Errorf("min weight percent must be between 0 and 100, got %d", slowStartConfig.MinWeightPercent)
+	}
+
+	ramp := &slowStart{
+		window:     secondsOr(slowStartConfig.WindowSeconds, 0),
+		aggression: slowStartConfig.Aggression,
+		minWeight:  float64(slowStartConfig.MinWeightPercent) / 100,
+	}
+	if ramp.aggression == 0 {
+		ramp.aggression = defaultSlowStartAggression
+	}
+	if slowStartConfig.MinWeightPercent == 0 {
+		ramp.minWeight = defaultSlowStartMinWeightPercent / 100.0
+	}
+
+	h.mu.Lock()
+	h.slowStart = ramp
+	h.mu.Unlock()
+
+	return nil
+}
+
+// startSlowStart begins the ramp up of a server that came back up.
+func (h *Handler) startSlowStart(server *Server) {
+	if h.slowStart != nil {
+		atomic.StoreInt64(&server.slowStartSince, time.Now().UnixNano())
+	}
+}
+
+// weightFactor returns the share of its weight a server gets, 1 once its
+// ramp up is over.
+func (s *slowStart) weightFactor(server *Server) float64 {
+	since := atomic.LoadInt64(&server.slowStartSince)
+	if since == 0 {
+		return 1
+	}
+
+	elapsed := time.Since(time.Unix(0, since))
+	if elapsed >= s.window {
+		atomic.CompareAndSwapInt64(&server.slowStartSince, since, 0)
+		return 1
+	}
+	return max(s.minWeight, math.Pow(elapsed.Seconds()/s.window.Seconds(), 1/s.aggression))
+}
+
+// admits reports whether a picked server is used, which for a server in slow
+// start is random in proportion to how far it is from its full weight.
+func (h *Handler) admits(server *Server) bool {
+	return h.slowStart == nil || rand.Float64() < h.slowStart.weightFactor(server)
+}
+
+// warm reports whether server gets its full weight.
+func (h *Handler) warm(server *Server) bool {
+	return h.slowStart == nil || h.slowStart.weightFactor(server) == 1
+}
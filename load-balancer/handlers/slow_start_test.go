@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"emaiorov/load-balancer/config"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSlowStartWeightFactor(t *testing.T) {
+	testCases := []struct {
+		name           string
+		aggression     float64
+		elapsed        time.Duration
+		expectedFactor float64
+	}{
+		{name: "CaseJustStarted", aggression: 1, elapsed: 0, expectedFactor: 0.1},
+		{name: "CaseLinearHalfway", aggression: 1, elapsed: 50 * time.Second, expectedFactor: 0.5},
+		{name: "CaseAggressiveQuarter", aggression: 2, elapsed: 25 * time.Second, expectedFactor: 0.5},
+		{name: "CaseOver", aggression: 1, elapsed: 100 * time.Second, expectedFactor: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ramp := &slowStart{window: 100 * time.Second, aggression: tc.aggression, minWeight: 0.1}
+			server := &Server{slowStartSince: time.Now().Add(-tc.elapsed).UnixNano()}
+
+			if factor := ramp.weightFactor(server); math.Abs(factor-tc.expectedFactor) > 0.01 {
+				t.Errorf("Wrong factor: got %v, want %v", factor, tc.expectedFactor)
+			}
+			if tc.expectedFactor == 1 && server.slowStartSince != 0 {
+				t.Errorf("Slow start not cleared after the window")
+			}
+		})
+	}
+}
+
+func TestSlowStartShare(t *testing.T) {
+	for _, name := range []string{"RoundRobin", "ConsistentHash", "LeastConnections", "PowerOfTwoChoices", "PeakEWMA"} {
+		t.Run(name, func(t *testing.T) {
+			handler, err := NewHandler(name, []Server{
+				{ServerConfig: config.ServerConfig{Url: "http://server1", Weight: 1}, IsAlive: true},
+				{ServerConfig: config.ServerConfig{Url: "http://server2", Weight: 1}, IsAlive: true},
+			}, &config.Config{SlowStart: config.SlowStartConfig{WindowSeconds: 3600, MinWeightPercent: 20}})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			cold := handler.Servers[0]
+			handler.SetAlive(cold, false)
+			handler.SetAlive(cold, true)
+
+			picks := 0
+			for i := range 2000 {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.RemoteAddr = fmt.Sprintf("10.0.%d.%d:1234", i/256, i%256)
+				server, err := handler.pick(req)
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				if server == cold {
+					picks++
+				}
+				handler.strategy.Done(server, Result{})
+			}
+
+			// Its full share would be about 1000
+			if picks < 100 || picks > 550 {
+				t.Errorf("Wrong number of picks of the server in slow start: got %d", picks)
+			}
+			for _, server := range handler.Servers {
+				if inFlight := atomic.LoadInt64(&server.InFlight); inFlight != 0 {
+					t.Errorf("Wrong InFlight of %s: got %d, want 0", server.Url, inFlight)
+				}
+			}
+		})
+	}
+}
+
+func TestEnableSlowStartErrors(t *testing.T) {
+	for _, slowStartConfig := range []config.SlowStartConfig{
+		{WindowSeconds: 0},
+		{WindowSeconds: 10, Aggression: -1},
+		{WindowSeconds: 10, MinWeightPercent: 101},
+	} {
+		h := &Handler{}
+		if err := h.EnableSlowStart(slowStartConfig); err == nil {
+			t.Errorf("Expected error for %+v", slowStartConfig)
+		}
+	}
+}
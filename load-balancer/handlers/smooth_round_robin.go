@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// SmoothRoundRobinHandler implements nginx's smooth weighted round robin.
+// Instead of sending Weight requests in a row to the same server, picks are
+// interleaved: weights 5, 1, 1 give the sequence a a b a c a a.
+type SmoothRoundRobinHandler struct {
+	Handler
+}
+
+func NewSmoothRoundRobinHandler(servers []Server) *SmoothRoundRobinHandler {
+	return newSmoothRoundRobinHandler(serverPointers(servers))
+}
+
+func newSmoothRoundRobinHandler(serversPtrs []*Server) *SmoothRoundRobinHandler {
+	for _, server := range serversPtrs {
+		if server.Weight == 0 {
+			server.Weight = 1
+		}
+		server.CurrentWeight = 0
+		server.EffectiveWeight = int(server.Weight)
+	}
+
+	handler := &SmoothRoundRobinHandler{
+		Handler: Handler{
+			Servers: serversPtrs,
+		},
+	}
+	handler.strategy = handler
+
+	return handler
+}
+
+func (h *SmoothRoundRobinHandler) GetServer() (*Server, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var best *Server
+	total := 0
+	for _, server := range h.Servers {
+		if !server.Available() {
+			continue
+		}
+		server.CurrentWeight += server.EffectiveWeight
+		total += server.EffectiveWeight
+
+		// Effective weight recovers one step per round after a failure
+		if server.EffectiveWeight < int(server.Weight) {
+			server.EffectiveWeight++
+		}
+
+		if best == nil || server.CurrentWeight > best.CurrentWeight {
+			best = server
+		}
+	}
+
+	if best == nil {
+		return &Server{}, fmt.Errorf("no active destinations")
+	}
+
+	best.CurrentWeight -= total
+	return best, nil
+}
+
+// ReportFailure drops the effective weight of the server to zero, as nginx
+// does with max_fails=1, so it gets traffic back gradually.
+func (h *SmoothRoundRobinHandler) ReportFailure(server *Server) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	server.EffectiveWeight = 0
+}
+
+func (h *SmoothRoundRobinHandler) Pick(r *http.Request) (*Server, error) {
+	return h.GetServer()
+}
+
+func (h *SmoothRoundRobinHandler) Done(server *Server, result Result) {
+	if result.Err != nil {
+		h.ReportFailure(server)
+	}
+}
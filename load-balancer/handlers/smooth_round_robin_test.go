@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"emaiorov/load-balancer/config"
+	"io"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestSmoothRoundRobinSequence(t *testing.T) {
+	testCases := []struct {
+		name             string
+		servers          []Server
+		expectedSequence string
+	}{
+		{
+			name: "CaseNginxExampleIsInterleaved",
+			servers: []Server{
+				{ServerConfig: config.ServerConfig{Url: "a", Weight: 5}, IsAlive: true},
+				{ServerConfig: config.ServerConfig{Url: "b", Weight: 1}, IsAlive: true},
+				{ServerConfig: config.ServerConfig{Url: "c", Weight: 1}, IsAlive: true},
+			},
+			expectedSequence: "aabacaa",
+		},
+		{
+			name: "CaseWithZeroWeightsInterpretAsOne",
+			servers: []Server{
+				{ServerConfig: config.ServerConfig{Url: "a", Weight: 0}, IsAlive: true},
+				{ServerConfig: config.ServerConfig{Url: "b", Weight: 0}, IsAlive: true},
+			},
+			expectedSequence: "abab",
+		},
+		{
+			name: "CaseDeadServerIsSkipped",
+			servers: []Server{
+				{ServerConfig: config.ServerConfig{Url: "a", Weight: 2}, IsAlive: true},
+				{ServerConfig: config.ServerConfig{Url: "b", Weight: 9}, IsAlive: false},
+				{ServerConfig: config.ServerConfig{Url: "c", Weight: 1}, IsAlive: true},
+			},
+			expectedSequence: "acaaca",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			swrrHandler := NewSmoothRoundRobinHandler(tc.servers)
+
+			var sequence strings.Builder
+			for range len(tc.expectedSequence) {
+				server, err := swrrHandler.GetServer()
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				sequence.WriteString(server.Url)
+			}
+
+			if sequence.String() != tc.expectedSequence {
+				t.Errorf("Wrong sequence: got %s, want %s", sequence.String(), tc.expectedSequence)
+			}
+		})
+	}
+}
+
+func TestSmoothRoundRobinReportFailure(t *testing.T) {
+	servers := []Server{
+		{ServerConfig: config.ServerConfig{Url: "a", Weight: 3}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: "b", Weight: 3}, IsAlive: true},
+	}
+	swrrHandler := NewSmoothRoundRobinHandler(servers)
+	failed := swrrHandler.Servers[0]
+
+	swrrHandler.ReportFailure(failed)
+	if failed.EffectiveWeight != 0 {
+		t.Fatalf("Wrong EffectiveWeight after failure: got %d, want %d", failed.EffectiveWeight, 0)
+	}
+
+	server, _ := swrrHandler.GetServer()
+	if server.Url != "b" {
+		t.Errorf("Failed server picked right after failure: got %s, want b", server.Url)
+	}
+
+	for range 3 {
+		swrrHandler.GetServer()
+	}
+	if failed.EffectiveWeight != int(failed.Weight) {
+		t.Errorf("EffectiveWeight did not recover: got %d, want %d", failed.EffectiveWeight, failed.Weight)
+	}
+}
+
+func TestSmoothRoundRobinIgnoresClientCancellation(t *testing.T) {
+	swrrHandler := NewSmoothRoundRobinHandler([]Server{
+		{ServerConfig: config.ServerConfig{Url: newHangingBackend(t).URL, Weight: 3}, IsAlive: true},
+	})
+	swrrHandler.Logger = log.New(io.Discard, "", 0)
+
+	sendCanceled(&swrrHandler.Handler, 1)
+
+	swrrHandler.mu.Lock()
+	defer swrrHandler.mu.Unlock()
+	if server := swrrHandler.Servers[0]; server.EffectiveWeight != int(server.Weight) {
+		t.Errorf("Wrong EffectiveWeight after a client timeout: got %d, want %d", server.EffectiveWeight, server.Weight)
+	}
+}
+
+func TestSmoothRoundRobinAllServersDown(t *testing.T) {
+	servers := []Server{
+		{ServerConfig: config.ServerConfig{Url: "a", Weight: 1}, IsAlive: false},
+	}
+	swrrHandler := NewSmoothRoundRobinHandler(servers)
+
+	if _, err := swrrHandler.GetServer(); err == nil {
+		t.Errorf("Expected error that no servers found")
+	}
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"emaiorov/load-balancer/config"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultStickyCookieName = "lb_affinity"
+
+type stickySessions struct {
+	config   config.StickyConfig
+	key      []byte
+	sameSite http.SameSite
+	servers  map[string]*Server
+	ids      map[*Server]string
+}
+
+// EnableStickySessions makes handlers that support it pin clients to the
+// server chosen on their first request. The affinity cookie has the form
+// id.expires.signature, where id is an HMAC of the server URL, so the cookie
+// neither reveals backend addresses nor can be forged without the key. It
+// must be called before the first request.
+func (h *Handler) EnableStickySessions(stickyConfig config.StickyConfig) error {
+	if stickyConfig.Key == "" {
+		return fmt.Errorf("sticky sessions require a signing key")
+	}
+	if stickyConfig.CookieName == "" {
+		stickyConfig.CookieName = defaultStickyCookieName
+	}
+
+	var sameSite http.SameSite
+	switch strings.ToLower(stickyConfig.SameSite) {
+	case "":
+		sameSite = http.SameSiteDefaultMode
+	case "lax":
+		sameSite = http.SameSiteLaxMode
+	case "strict":
+		sameSite = http.SameSiteStrictMode
+	case "none":
+		sameSite = http.SameSiteNoneMode
+	default:
+		return fmt.Errorf("unknown same_site value '%s'", stickyConfig.SameSite)
+	}
+
+	sticky := &stickySessions{
+		config:   stickyConfig,
+		key:      []byte(stickyConfig.Key),
+		sameSite: sameSite,
+		servers:  make(map[string]*Server, len(h.Servers)),
+		ids:      make(map[*Server]string, len(h.Servers)),
+	}
+	for _, server := range h.Servers {
+		id := hex.EncodeToString(sticky.sign(server.Url))[:16]
+		sticky.servers[id] = server
+		sticky.ids[server] = id
+	}
+
+	h.mu.Lock()
+	h.sticky = sticky
+	h.mu.Unlock()
+
+	return nil
+}
+
+func (s *stickySessions) sign(value string) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
+
+// stickyServer returns the live server pinned by a valid affinity cookie,
+// or nil when there is none.
+func (h *Handler) stickyServer(r *http.Request) *Server {
+	sticky := h.sticky
+	if sticky == nil {
+		return nil
+	}
+
+	cookie, err := r.Cookie(sticky.config.CookieName)
+	if err != nil {
+		return nil
+	}
+
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, sticky.sign(parts[0]+"."+parts[1])) {
+		return nil
+	}
+
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || (expires != 0 && time.Now().Unix() > expires) {
+		return nil
+	}
+
+	server := sticky.servers[parts[0]]
+	if server == nil {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !server.Available() {
+		return nil
+	}
+	return server
+}
+
+// resetStickyCookie points the affinity cookie of the response to server,
+// after a retry moved the request there.
+func (h *Handler) resetStickyCookie(w http.ResponseWriter, server *Server) {
+	sticky := h.sticky
+	if sticky == nil {
+		return
+	}
+
+	prefix := sticky.config.CookieName + "="
+	header := w.Header()
+	header["Set-Cookie"] = slices.DeleteFunc(header["Set-Cookie"], func(cookie string) bool {
+		return strings.HasPrefix(cookie, prefix)
+	})
+	h.setStickyCookie(w, server)
+}
+
+// setStickyCookie pins the client to server.
+func (h *Handler) setStickyCookie(w http.ResponseWriter, server *Server) {
+	sticky := h.sticky
+	if sticky == nil {
+		return
+	}
+
+	var expires int64
+	cookie := &http.Cookie{
+		Name:     sticky.config.CookieName,
+		Path:     "/",
+		Secure:   sticky.config.Secure,
+		HttpOnly: sticky.config.HttpOnly,
+		SameSite: sticky.sameSite,
+	}
+	if sticky.config.TTLSeconds > 0 {
+		expires = time.Now().Add(time.Duration(sticky.config.TTLSeconds) * time.Second).Unix()
+		cookie.MaxAge = sticky.config.TTLSeconds
+	}
+
+	payload := sticky.ids[server] + "." + strconv.FormatInt(expires, 10)
+	cookie.Value = payload + "." + base64.RawURLEncoding.EncodeToString(sticky.sign(payload))
+
+	http.SetCookie(w, cookie)
+}
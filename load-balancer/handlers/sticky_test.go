@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"emaiorov/load-balancer/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var testStickyConfig = config.StickyConfig{
+	Enabled:    true,
+	CookieName: "lb",
+	TTLSeconds: 60,
+	Secure:     true,
+	HttpOnly:   true,
+	SameSite:   "Strict",
+	Key:        "secret",
+}
+
+// newNamedBackend answers with its name followed by the request body.
+func newNamedBackend(t testing.TB, name string) *httptest.Server {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(name))
+		io.Copy(w, r.Body)
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+// newHangingBackend holds every request until the client gives up.
+func newHangingBackend(t testing.TB) *httptest.Server {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+// serve returns the response of the handler to req and its body.
+func serve(handler http.Handler, req *http.Request) (*http.Response, string) {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	resp := w.Result()
+	body, _ := io.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+// sendWithCookie returns the body and the affinity cookie set by the handler.
+func sendWithCookie(handler http.Handler, cookie *http.Cookie) (string, *http.Cookie) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+
+	resp, body := serve(handler, req)
+	for _, setCookie := range resp.Cookies() {
+		if setCookie.Name == testStickyConfig.CookieName {
+			return body, setCookie
+		}
+	}
+	return body, nil
+}
+
+func TestStickySessions(t *testing.T) {
+	backend1 := newNamedBackend(t, "backend-1")
+	backend2 := newNamedBackend(t, "backend-2")
+
+	testCases := []struct {
+		name       string
+		newHandler func(servers []Server) (http.Handler, *Handler)
+	}{
+		{
+			name: "RoundRobin",
+			newHandler: func(servers []Server) (http.Handler, *Handler) {
+				rrHandler := NewRoundRobinHandler(servers)
+				return rrHandler, &rrHandler.Handler
+			},
+		},
+		{
+			name: "LeastConnections",
+			newHandler: func(servers []Server) (http.Handler, *Handler) {
+				lcHandler := NewLeastConnectionsHandler(servers)
+				return lcHandler, &lcHandler.Handler
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			servers := []Server{
+				{ServerConfig: config.ServerConfig{Url: backend1.URL}, IsAlive: true},
+				{ServerConfig: config.ServerConfig{Url: backend2.URL}, IsAlive: true},
+			}
+			lb, handler := tc.newHandler(servers)
+			if err := handler.EnableStickySessions(testStickyConfig); err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+
+			pinned, cookie := sendWithCookie(lb, nil)
+			if cookie == nil {
+				t.Fatalf("No affinity cookie set")
+			}
+			if strings.Contains(cookie.Value, "127.0.0.1") || strings.Contains(cookie.Value, "http") {
+				t.Errorf("Cookie leaks the backend URL: %s", cookie.Value)
+			}
+			if !cookie.Secure || !cookie.HttpOnly || cookie.SameSite != http.SameSiteStrictMode || cookie.MaxAge != 60 {
+				t.Errorf("Wrong cookie attributes: %+v", cookie)
+			}
+
+			for range 4 {
+				body, setCookie := sendWithCookie(lb, cookie)
+				if body != pinned {
+					t.Errorf("Request with cookie went to %s, want %s", body, pinned)
+				}
+				if setCookie != nil {
+					t.Errorf("Valid cookie was replaced")
+				}
+			}
+
+			forged := *cookie
+			forged.Value = strings.Replace(cookie.Value, ".", "x.", 1)
+			if _, setCookie := sendWithCookie(lb, &forged); setCookie == nil {
+				t.Errorf("Forged cookie was accepted")
+			}
+
+			pinnedUrl := map[string]string{"backend-1": backend1.URL, "backend-2": backend2.URL}[pinned]
+			for _, server := range handler.Servers {
+				if server.Url == pinnedUrl {
+					handler.SetAlive(server, false)
+				}
+			}
+			body, setCookie := sendWithCookie(lb, cookie)
+			if body == pinned {
+				t.Errorf("Request went to the dead server %s", pinned)
+			}
+			if setCookie == nil || setCookie.Value == cookie.Value {
+				t.Errorf("Client was not re-cookied after rebalancing")
+			}
+		})
+	}
+}
+
+func TestStickySessionsCountLoad(t *testing.T) {
+	backend := newNamedBackend(t, "backend")
+	servers := []Server{
+		{ServerConfig: config.ServerConfig{Url: backend.URL}, IsAlive: true},
+	}
+	lcHandler := NewLeastConnectionsHandler(servers)
+	lcHandler.EnableStickySessions(testStickyConfig)
+
+	_, cookie := sendWithCookie(lcHandler, nil)
+	sendWithCookie(lcHandler, cookie)
+
+	if lcHandler.Servers[0].LoadScore != 0 {
+		t.Errorf("Wrong LoadScore after requests finished: got %d, want 0", lcHandler.Servers[0].LoadScore)
+	}
+}
+
+func TestEnableStickySessionsErrors(t *testing.T) {
+	for _, stickyConfig := range []config.StickyConfig{
+		{Enabled: true},
+		{Enabled: true, Key: "secret", SameSite: "sometimes"},
+	} {
+		var h Handler
+		if err := h.EnableStickySessions(stickyConfig); err == nil {
+			t.Errorf("Expected error for sticky config %+v", stickyConfig)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"emaiorov/load-balancer/config"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Strategy chooses the server for each request. The Handler running it takes
+// care of health checks, sticky sessions, proxying and errors.
+type Strategy interface {
+	// Pick returns the server for the request, or an error when none is
+	// available.
+	Pick(r *http.Request) (*Server, error)
+	// Done is called once for every server a request was routed to, when its
+	// response body is closed, the backend could not be reached or the server
+	// was passed over. This includes servers Pick did not return: those of
+	// sticky sessions, circuit breaker trials and the random fallback, see
+	// Acquirer.
+	Done(server *Server, result Result)
+	// UpdateMembership is called with the available servers whenever they
+	// change, and once when the Handler is created.
+	UpdateMembership(servers []*Server)
+}
+
+// Acquirer is implemented by strategies counting requests in flight, so a
+// request routed without Pick is counted as well: one pinned by sticky
+// sessions, sent as a circuit breaker trial, or sent to a random server after
+// Pick kept returning unusable ones.
+type Acquirer interface {
+	Acquire(server *Server)
+}
+
+// StrategyFactory builds a strategy for the servers of a Handler.
+type StrategyFactory func(servers []*Server, appConfig *config.Config) (Strategy, error)
+
+var (
+	strategiesMu sync.RWMutex
+	strategies   = make(map[string]StrategyFactory)
+)
+
+// RegisterStrategy makes a strategy available under name for the algorythm
+// setting. It panics if the name is taken, like database/sql.Register.
+func RegisterStrategy(name string, factory StrategyFactory) {
+	strategiesMu.Lock()
+	defer strategiesMu.Unlock()
+
+	if factory == nil {
+		panic("handlers: RegisterStrategy factory is nil")
+	}
+	if _, taken := strategies[name]; taken {
+		panic("handlers: RegisterStrategy called twice for " + name)
+	}
+	strategies[name] = factory
+}
+
+// Strategies returns the sorted names of the registered strategies.
+func Strategies() []string {
+	strategiesMu.RLock()
+	defer strategiesMu.RUnlock()
+
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterStrategy("RoundRobin", func(servers []*Server, appConfig *config.Config) (Strategy, error) {
+		return newRoundRobinHandler(servers), nil
+	})
+	RegisterStrategy("SmoothRoundRobin", func(servers []*Server, appConfig *config.Config) (Strategy, error) {
+		return newSmoothRoundRobinHandler(servers), nil
+	})
+	RegisterStrategy("LeastConnections", func(servers []*Server, appConfig *config.Config) (Strategy, error) {
+		return newLeastConnectionsHandler(servers), nil
+	})
+	RegisterStrategy("ConsistentHash", func(servers []*Server, appConfig *config.Config) (Strategy, error) {
+		return newConsistentHashHandler(servers, appConfig.Hash)
+	})
+	RegisterStrategy("Maglev", func(servers []*Server, appConfig *config.Config) (Strategy, error) {
+		return newMaglevHandler(servers, appConfig.Hash, appConfig.Maglev)
+	})
+	RegisterStrategy("Rendezvous", func(servers []*Server, appConfig *config.Config) (Strategy, error) {
+		return newRendezvousHandler(servers, appConfig.Hash)
+	})
+	RegisterStrategy("PowerOfTwoChoices", func(servers []*Server, appConfig *config.Config) (Strategy, error) {
+		return newPowerOfTwoChoicesHandler(servers), nil
+	})
+	RegisterStrategy("PeakEWMA", func(servers []*Server, appConfig *config.Config) (Strategy, error) {
+		return newPeakEWMAHandler(servers, appConfig.PeakEWMA), nil
+	})
+	RegisterStrategy("LeastResponseTime", func(servers []*Server, appConfig *config.Config) (Strategy, error) {
+		return newLeastResponseTimeHandler(servers), nil
+	})
+	RegisterStrategy("IPHash", func(servers []*Server, appConfig *config.Config) (Strategy, error) {
+		return newIPHashHandler(servers, appConfig.Hash)
+	})
+	RegisterStrategy("WeightedRandom", func(servers []*Server, appConfig *config.Config) (Strategy, error) {
+		return newWeightedRandomHandler(servers), nil
+	})
+}
+
+// NewHandler builds a Handler running the strategy registered under name,
+// RoundRobin when name is empty, with the sticky session and failover
+// settings of appConfig.
+func NewHandler(name string, servers []Server, appConfig *config.Config) (*Handler, error) {
+	if name == "" {
+		name = "RoundRobin"
+	}
+
+	strategiesMu.RLock()
+	factory, ok := strategies[name]
+	strategiesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown algorythm '%s'", name)
+	}
+
+	serversPtrs := serverPointers(servers)
+	strategy, err := factory(serversPtrs, appConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// The built-in strategies embed the Handler they run in
+	handler := &Handler{Servers: serversPtrs}
+	if embedded, ok := strategy.(interface{ base() *Handler }); ok && embedded.base().strategy == strategy {
+		handler = embedded.base()
+	}
+	handler.strategy = strategy
+	handler.Transport = NewTransport(appConfig.Transport)
+
+	if err := handler.prepareHealthChecks(); err != nil {
+		return nil, err
+	}
+
+	if appConfig.Sticky.Enabled {
+		if err := handler.EnableStickySessions(appConfig.Sticky); err != nil {
+			return nil, err
+		}
+	}
+
+	if appConfig.CircuitBreaker.Enabled {
+		if err := handler.EnableCircuitBreakers(appConfig.CircuitBreaker); err != nil {
+			return nil, err
+		}
+	}
+
+	if appConfig.SlowStart.WindowSeconds > 0 {
+		if err := handler.EnableSlowStart(appConfig.SlowStart); err != nil {
+			return nil, err
+		}
+	}
+
+	if appConfig.Retry.MaxAttempts > 1 {
+		if err := handler.EnableRetries(appConfig.Retry); err != nil {
+			return nil, err
+		}
+	}
+
+	if appConfig.Outlier.Enabled {
+		if err := handler.EnableOutlierDetection(appConfig.Outlier); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := handler.EnablePriorityFailover(appConfig.Failover.MinHealthyPercent); err != nil {
+		return nil, err
+	}
+
+	handler.updateMembership()
+
+	return handler, nil
+}
+
+// Strategy returns the strategy choosing the servers of the handler.
+func (h *Handler) Strategy() Strategy {
+	return h.strategy
+}
+
+func (h *Handler) base() *Handler {
+	return h
+}
+
+// Done does nothing, for strategies keeping no per-request state.
+func (h *Handler) Done(server *Server, result Result) {}
+
+// UpdateMembership does nothing. Strategies embedding Handler check
+// Server.Available themselves.
+func (h *Handler) UpdateMembership(servers []*Server) {}
+
+func serverPointers(servers []Server) []*Server {
+	serversPtrs := make([]*Server, len(servers))
+
+	for i := range servers {
+		serversPtrs[i] = &servers[i]
+	}
+	return serversPtrs
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"emaiorov/load-balancer/config"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// firstStrategy always picks the first available server, like a strategy
+// registered from outside the package would.
+type firstStrategy struct {
+	mu        sync.Mutex
+	available []*Server
+	done      []Result
+}
+
+func (s *firstStrategy) Pick(r *http.Request) (*Server, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.available) == 0 {
+		return nil, fmt.Errorf("no active destinations")
+	}
+	return s.available[0], nil
+}
+
+func (s *firstStrategy) Done(server *Server, result Result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.done = append(s.done, result)
+}
+
+func (s *firstStrategy) UpdateMembership(servers []*Server) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.available = servers
+}
+
+var registerFirst sync.Once
+
+func TestRegisteredStrategy(t *testing.T) {
+	registerFirst.Do(func() {
+		RegisterStrategy("First", func(servers []*Server, appConfig *config.Config) (Strategy, error) {
+			return &firstStrategy{}, nil
+		})
+	})
+
+	if !slices.Contains(Strategies(), "First") || !slices.Contains(Strategies(), "RoundRobin") {
+		t.Errorf("Missing registered strategies: %v", Strategies())
+	}
+
+	backend1 := newNamedBackend(t, "backend-1")
+	backend2 := newNamedBackend(t, "backend-2")
+	handler, err := NewHandler("First", []Server{
+		{ServerConfig: config.ServerConfig{Url: backend1.URL}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: backend2.URL}, IsAlive: true},
+	}, &config.Config{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	strategy, ok := handler.Strategy().(*firstStrategy)
+	if !ok {
+		t.Fatalf("Handler does not run the registered strategy")
+	}
+
+	if body, _ := sendWithCookie(handler, nil); body != "backend-1" {
+		t.Errorf("Wrong backend: got %s, want backend-1", body)
+	}
+
+	handler.SetAlive(handler.Servers[0], false)
+	if body, _ := sendWithCookie(handler, nil); body != "backend-2" {
+		t.Errorf("Wrong backend after membership change: got %s, want backend-2", body)
+	}
+
+	handler.SetAlive(handler.Servers[1], false)
+	if body, _ := sendWithCookie(handler, nil); body != "All servers failed on health check" {
+		t.Errorf("Wrong response without servers: got %s", body)
+	}
+
+	strategy.mu.Lock()
+	defer strategy.mu.Unlock()
+	if len(strategy.done) != 2 {
+		t.Errorf("Wrong number of finished requests: got %d, want 2", len(strategy.done))
+	}
+}
+
+func TestNewHandlerBuiltInStrategies(t *testing.T) {
+	for _, name := range []string{"", "RoundRobin", "SmoothRoundRobin", "LeastConnections", "ConsistentHash",
+		"Maglev", "Rendezvous", "PowerOfTwoChoices", "PeakEWMA", "LeastResponseTime", "IPHash", "WeightedRandom"} {
+		t.Run(name, func(t *testing.T) {
+			backend := newNamedBackend(t, "backend")
+			handler, err := NewHandler(name, []Server{
+				{ServerConfig: config.ServerConfig{Url: backend.URL}, IsAlive: true},
+			}, &config.Config{})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+
+			if body, _ := sendWithCookie(handler, nil); body != "backend" {
+				t.Errorf("Wrong backend: got %s, want backend", body)
+			}
+		})
+	}
+}
+
+func TestStickySessionsAcquire(t *testing.T) {
+	backend := newNamedBackend(t, "backend")
+	handler, err := NewHandler("PowerOfTwoChoices", []Server{
+		{ServerConfig: config.ServerConfig{Url: backend.URL}, IsAlive: true},
+	}, &config.Config{Sticky: testStickyConfig})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	_, cookie := sendWithCookie(handler, nil)
+	sendWithCookie(handler, cookie)
+
+	if inFlight := atomic.LoadInt64(&handler.Servers[0].InFlight); inFlight != 0 {
+		t.Errorf("Wrong InFlight after requests finished: got %d, want 0", inFlight)
+	}
+}
+
+func TestNewHandlerErrors(t *testing.T) {
+	if _, err := NewHandler("Unknown", nil, &config.Config{}); err == nil {
+		t.Errorf("Expected error for an unknown algorythm")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected panic when registering a name twice")
+		}
+	}()
+	RegisterStrategy("RoundRobin", func(servers []*Server, appConfig *config.Config) (Strategy, error) {
+		return nil, nil
+	})
+}
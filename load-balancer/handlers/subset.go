@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"cmp"
+	"emaiorov/load-balancer/config"
+	"fmt"
+	"slices"
+)
+
+// Subset returns the servers this balancer instance should use. The servers
+// are put in a stable order by the hash of their url and every instance
+// takes a window of that order starting at instanceID*len(servers)/replicas,
+// so each server is used by the same number of instances, give or take one.
+// Adding or removing a server moves each window by at most one position,
+// which changes only a couple of servers per instance.
+func Subset(servers []Server, subsetConfig config.SubsetConfig) ([]Server, error) {
+	if subsetConfig.Replicas == 0 {
+		return servers, nil
+	}
+	if subsetConfig.Replicas < 0 {
+		return nil, fmt.Errorf("subset replicas must be positive, got %d", subsetConfig.Replicas)
+	}
+	if subsetConfig.InstanceID < 0 || subsetConfig.InstanceID >= subsetConfig.Replicas {
+		return nil, fmt.Errorf("subset instance id must be between 0 and %d, got %d", subsetConfig.Replicas-1, subsetConfig.InstanceID)
+	}
+	if subsetConfig.Size < 0 {
+		return nil, fmt.Errorf("subset size must not be negative, got %d", subsetConfig.Size)
+	}
+
+	total := len(servers)
+	size := subsetConfig.Size
+	if size == 0 {
+		size = (total + subsetConfig.Replicas - 1) / subsetConfig.Replicas
+	}
+	if size >= total {
+		return servers, nil
+	}
+
+	ordered := slices.Clone(servers)
+	slices.SortStableFunc(ordered, func(a, b Server) int {
+		return cmp.Compare(hashString(a.Url, "subset"), hashString(b.Url, "subset"))
+	})
+
+	start := subsetConfig.InstanceID * total / subsetConfig.Replicas
+	subset := make([]Server, 0, size)
+	for i := range size {
+		subset = append(subset, ordered[(start+i)%total])
+	}
+	return subset, nil
+}
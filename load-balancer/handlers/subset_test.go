@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"emaiorov/load-balancer/config"
+	"testing"
+)
+
+func subsetUrls(t *testing.T, servers []Server, subsetConfig config.SubsetConfig) map[string]bool {
+	t.Helper()
+	subset, err := Subset(servers, subsetConfig)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	urls := map[string]bool{}
+	for _, server := range subset {
+		urls[server.Url] = true
+	}
+	return urls
+}
+
+func TestSubsetSpreadsServersEvenly(t *testing.T) {
+	testCases := []struct {
+		name     string
+		servers  int
+		replicas int
+		size     int
+		minUsers int
+		maxUsers int
+	}{
+		{name: "CaseDefaultSizeCoversEveryServerOnce", servers: 100, replicas: 10, minUsers: 1, maxUsers: 1},
+		{name: "CaseUnevenDefaultSize", servers: 100, replicas: 7, minUsers: 1, maxUsers: 2},
+		{name: "CaseOverlappingSubsets", servers: 300, replicas: 20, size: 45, minUsers: 3, maxUsers: 3},
+		{name: "CaseMoreReplicasThanServers", servers: 5, replicas: 12, size: 2, minUsers: 4, maxUsers: 6},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			servers := newPoolServers(tc.servers)
+			users := map[string]int{}
+			for instance := range tc.replicas {
+				subset := subsetUrls(t, servers, config.SubsetConfig{InstanceID: instance, Replicas: tc.replicas, Size: tc.size})
+				for url := range subset {
+					users[url]++
+				}
+			}
+
+			for _, server := range servers {
+				if users[server.Url] < tc.minUsers || users[server.Url] > tc.maxUsers {
+					t.Errorf("Wrong number of instances using %s: got %d, want %d to %d", server.Url, users[server.Url], tc.minUsers, tc.maxUsers)
+				}
+			}
+		})
+	}
+}
+
+func TestSubsetIsStable(t *testing.T) {
+	subsetConfig := config.SubsetConfig{InstanceID: 3, Replicas: 10, Size: 10}
+	first := subsetUrls(t, newPoolServers(100), subsetConfig)
+
+	// Config order must not matter.
+	reversed := newPoolServers(100)
+	for i, j := 0, len(reversed)-1; i < j; i, j = i+1, j-1 {
+		reversed[i], reversed[j] = reversed[j], reversed[i]
+	}
+	second := subsetUrls(t, reversed, subsetConfig)
+
+	if len(first) != 10 || len(second) != 10 {
+		t.Fatalf("Wrong subset size: got %d and %d, want 10", len(first), len(second))
+	}
+	for url := range first {
+		if !second[url] {
+			t.Errorf("Subset changed with config order: %s missing", url)
+		}
+	}
+}
+
+func TestSubsetReshufflesMinimally(t *testing.T) {
+	for instance := range 10 {
+		subsetConfig := config.SubsetConfig{InstanceID: instance, Replicas: 10, Size: 10}
+		before := subsetUrls(t, newPoolServers(100), subsetConfig)
+		after := subsetUrls(t, newPoolServers(101), subsetConfig)
+
+		moved := 0
+		for url := range before {
+			if !after[url] {
+				moved++
+			}
+		}
+		if moved > 2 {
+			t.Errorf("Too many servers left the subset of instance %d: got %d, want at most 2", instance, moved)
+		}
+	}
+}
+
+func TestSubsetDisabledAndErrors(t *testing.T) {
+	servers := newPoolServers(10)
+	if subset, err := Subset(servers, config.SubsetConfig{}); err != nil || len(subset) != len(servers) {
+		t.Errorf("Disabled subsetting changed the servers: got %d, err %v", len(subset), err)
+	}
+
+	for _, subsetConfig := range []config.SubsetConfig{
+		{Replicas: -1},
+		{InstanceID: 3, Replicas: 3},
+		{InstanceID: -1, Replicas: 3},
+		{Replicas: 3, Size: -1},
+	} {
+		if _, err := Subset(servers, subsetConfig); err == nil {
+			t.Errorf("Expected error for subset config %+v", subsetConfig)
+		}
+	}
+}
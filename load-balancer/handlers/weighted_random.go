@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"sync/atomic"
+)
+
+type aliasTable struct {
+	generation  uint64
+	servers     []*Server
+	probability []float64
+	alias       []int
+}
+
+// WeightedRandomHandler picks a live server at random with probability
+// proportional to its weight, in O(1) with Vose's alias method. It keeps no
+// per-request state, so replicas behave identically, and the alias table is
+// only rebuilt when the live set changes.
+type WeightedRandomHandler struct {
+	Handler
+	table atomic.Pointer[aliasTable]
+}
+
+func NewWeightedRandomHandler(servers []Server) *WeightedRandomHandler {
+	return newWeightedRandomHandler(serverPointers(servers))
+}
+
+func newWeightedRandomHandler(serversPtrs []*Server) *WeightedRandomHandler {
+	for _, server := range serversPtrs {
+		if server.Weight == 0 {
+			server.Weight = 1
+		}
+	}
+
+	handler := &WeightedRandomHandler{
+		Handler: Handler{
+			Servers: serversPtrs,
+		},
+	}
+	handler.strategy = handler
+
+	return handler
+}
+
+func (h *WeightedRandomHandler) aliasTable() *aliasTable {
+	if table := h.table.Load(); table != nil && table.generation == h.generation.Load() {
+		return table
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	table := &aliasTable{generation: h.generation.Load()}
+	var totalWeight float64
+	for _, server := range h.Servers {
+		if server.Available() {
+			table.servers = append(table.servers, server)
+			totalWeight += float64(server.Weight)
+		}
+	}
+
+	count := len(table.servers)
+	table.probability = make([]float64, count)
+	table.alias = make([]int, count)
+
+	scaled := make([]float64, count)
+	var small, large []int
+	for i, server := range table.servers {
+		scaled[i] = float64(server.Weight) * float64(count) / totalWeight
+		if scaled[i] < 1 {
+			small = append(small, i)
+		} else {
+			large = append(large, i)
+		}
+	}
+
+	for len(small) > 0 && len(large) > 0 {
+		less := small[len(small)-1]
+		small = small[:len(small)-1]
+		more := large[len(large)-1]
+		large = large[:len(large)-1]
+
+		table.probability[less] = scaled[less]
+		table.alias[less] = more
+
+		scaled[more] = scaled[more] + scaled[less] - 1
+		if scaled[more] < 1 {
+			small = append(small, more)
+		} else {
+			large = append(large, more)
+		}
+	}
+
+	// Whatever is left is 1 up to floating point error
+	for _, i := range append(small, large...) {
+		table.probability[i] = 1
+	}
+
+	h.table.Store(table)
+	return table
+}
+
+func (h *WeightedRandomHandler) GetServer() (*Server, error) {
+	table := h.aliasTable()
+
+	if len(table.servers) == 0 {
+		return &Server{}, fmt.Errorf("no active destinations")
+	}
+
+	i := rand.IntN(len(table.servers))
+	if rand.Float64() < table.probability[i] {
+		return table.servers[i], nil
+	}
+	return table.servers[table.alias[i]], nil
+}
+
+func (h *WeightedRandomHandler) Pick(r *http.Request) (*Server, error) {
+	return h.GetServer()
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"emaiorov/load-balancer/config"
+	"math"
+	"testing"
+)
+
+func TestWeightedRandomDistribution(t *testing.T) {
+	servers := []Server{
+		{ServerConfig: config.ServerConfig{Url: "http://s1", Weight: 1}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: "http://s2", Weight: 2}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: "http://s3", Weight: 7}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: "http://s4", Weight: 50}, IsAlive: false},
+	}
+	wrHandler := NewWeightedRandomHandler(servers)
+
+	const picks = 100000
+	counts := map[string]int{}
+	for range picks {
+		server, err := wrHandler.GetServer()
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		counts[server.Url]++
+	}
+
+	expected := map[string]float64{"http://s1": 0.1, "http://s2": 0.2, "http://s3": 0.7, "http://s4": 0}
+	for url, share := range expected {
+		got := float64(counts[url]) / picks
+		if math.Abs(got-share) > 0.01 {
+			t.Errorf("Wrong share for %s: got %.3f, want %.3f", url, got, share)
+		}
+	}
+}
+
+func TestWeightedRandomRebuildsOnlyOnHealthChange(t *testing.T) {
+	servers := []Server{
+		{ServerConfig: config.ServerConfig{Url: "http://s1", Weight: 1}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: "http://s2", Weight: 1}, IsAlive: true},
+	}
+	wrHandler := NewWeightedRandomHandler(servers)
+
+	wrHandler.GetServer()
+	table := wrHandler.table.Load()
+	wrHandler.GetServer()
+	wrHandler.SetAlive(wrHandler.Servers[0], true)
+	wrHandler.GetServer()
+	if wrHandler.table.Load() != table {
+		t.Errorf("Alias table rebuilt without a health change")
+	}
+
+	wrHandler.SetAlive(wrHandler.Servers[0], false)
+	for range 20 {
+		server, _ := wrHandler.GetServer()
+		if server.Url != "http://s2" {
+			t.Fatalf("Wrong Server detected: got %s, want http://s2", server.Url)
+		}
+	}
+
+	wrHandler.SetAlive(wrHandler.Servers[1], false)
+	if _, err := wrHandler.GetServer(); err == nil {
+		t.Errorf("Expected error that no servers found")
+	}
+}
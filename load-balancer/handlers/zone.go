@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"emaiorov/load-balancer/config"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+)
+
+// Envoy's default: a zone at 71% health or more still takes all its traffic.
+const defaultOverprovisioningFactor = 1.4
+
+// HandlerFactory builds the handler of the configured algorythm for a pool.
+type HandlerFactory func(servers []Server) (*Handler, error)
+
+// ZoneAwareHandler splits the servers into a pool for the local zone and one
+// for every other zone, each balanced by the configured algorythm. Requests
+// stay in the local zone while its healthy weight share times the
+// overprovisioning factor is at least 1; below that only the shortfall is
+// sent cross-zone, like Envoy's locality weighted load balancing.
+type ZoneAwareHandler struct {
+	local                  *Handler
+	remote                 *Handler
+	overprovisioningFactor float64
+}
+
+func NewZoneAwareHandler(servers []Server, zoneConfig config.ZoneConfig, factory HandlerFactory) (*ZoneAwareHandler, error) {
+	if zoneConfig.LocalZone == "" {
+		return nil, fmt.Errorf("zone aware routing requires a local zone")
+	}
+
+	factor := zoneConfig.OverprovisioningFactor
+	if factor == 0 {
+		factor = defaultOverprovisioningFactor
+	}
+	if factor < 1 {
+		return nil, fmt.Errorf("overprovisioning factor must be at least 1, got %v", factor)
+	}
+
+	var localServers, remoteServers []Server
+	for _, server := range servers {
+		if server.Zone == zoneConfig.LocalZone {
+			localServers = append(localServers, server)
+		} else {
+			remoteServers = append(remoteServers, server)
+		}
+	}
+
+	local, err := factory(localServers)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := factory(remoteServers)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ZoneAwareHandler{
+		local:                  local,
+		remote:                 remote,
+		overprovisioningFactor: factor,
+	}, nil
+}
+
+// Handlers returns the handlers of the local and the remote pool, which
+// need to be health checked.
+func (h *ZoneAwareHandler) Handlers() []*Handler {
+	return []*Handler{h.local, h.remote}
+}
+
+// availableWeight sums the weight of available servers and of all servers.
+func (h *Handler) availableWeight() (available uint, total uint) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, server := range h.Servers {
+		weight := max(server.Weight, 1)
+		total += weight
+		if server.Available() {
+			available += weight
+		}
+	}
+	return available, total
+}
+
+// LocalShare returns the fraction of requests kept in the local zone.
+func (h *ZoneAwareHandler) LocalShare() float64 {
+	localAvailable, localTotal := h.local.availableWeight()
+	remoteAvailable, _ := h.remote.availableWeight()
+
+	switch {
+	case localAvailable == 0:
+		return 0
+	case remoteAvailable == 0:
+		return 1
+	}
+
+	return min(1, float64(localAvailable)/float64(localTotal)*h.overprovisioningFactor)
+}
+
+func (h *ZoneAwareHandler) pickPool() *Handler {
+	if rand.Float64() < h.LocalShare() {
+		return h.local
+	}
+	return h.remote
+}
+
+func (h *ZoneAwareHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Clients pinned to a live server stay in its pool, only the others are
+	// spilled over
+	for _, pool := range h.Handlers() {
+		if pool.stickyServer(r) != nil {
+			pool.ServeHTTP(w, r)
+			return
+		}
+	}
+	h.pickPool().ServeHTTP(w, r)
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"emaiorov/load-balancer/config"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func roundRobinFactory(servers []Server) (*Handler, error) {
+	return &NewRoundRobinHandler(servers).Handler, nil
+}
+
+func leastConnectionsFactory(servers []Server) (*Handler, error) {
+	return &NewLeastConnectionsHandler(servers).Handler, nil
+}
+
+func newZonedServers() []Server {
+	return []Server{
+		{ServerConfig: config.ServerConfig{Url: "http://a1", Zone: "a"}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: "http://a2", Zone: "a"}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: "http://b1", Zone: "b"}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: "http://c1", Zone: "c"}, IsAlive: true},
+	}
+}
+
+func TestZoneAwareLocalShare(t *testing.T) {
+	testCases := []struct {
+		name          string
+		deadLocal     int
+		deadRemote    int
+		expectedShare float64
+	}{
+		{name: "CaseHealthyLocalZoneKeepsAllTraffic", expectedShare: 1},
+		{name: "CaseDegradedLocalZoneSpillsShortfall", deadLocal: 1, expectedShare: 0.7},
+		{name: "CaseDeadLocalZoneSpillsAll", deadLocal: 2, expectedShare: 0},
+		{name: "CaseDeadRemoteZonesKeepLocal", deadLocal: 1, deadRemote: 2, expectedShare: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			zoneHandler, err := NewZoneAwareHandler(newZonedServers(), config.ZoneConfig{LocalZone: "a"}, roundRobinFactory)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+
+			local, remote := zoneHandler.Handlers()[0], zoneHandler.Handlers()[1]
+			for i := range tc.deadLocal {
+				local.SetAlive(local.Servers[i], false)
+			}
+			for i := range tc.deadRemote {
+				remote.SetAlive(remote.Servers[i], false)
+			}
+
+			if share := zoneHandler.LocalShare(); math.Abs(share-tc.expectedShare) > 1e-9 {
+				t.Errorf("Wrong local share: got %v, want %v", share, tc.expectedShare)
+			}
+		})
+	}
+}
+
+func TestZoneAwareComposesWithBaseAlgorithm(t *testing.T) {
+	testCases := []struct {
+		name    string
+		factory HandlerFactory
+	}{
+		{name: "RoundRobin", factory: roundRobinFactory},
+		{name: "LeastConnections", factory: leastConnectionsFactory},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			local1 := newNamedBackend(t, "local-1")
+			local2 := newNamedBackend(t, "local-2")
+			remote := newNamedBackend(t, "remote")
+
+			servers := []Server{
+				{ServerConfig: config.ServerConfig{Url: local1.URL, Zone: "a"}, IsAlive: true},
+				{ServerConfig: config.ServerConfig{Url: remote.URL, Zone: "b"}, IsAlive: true},
+				{ServerConfig: config.ServerConfig{Url: local2.URL, Zone: "a"}, IsAlive: true},
+			}
+			zoneHandler, err := NewZoneAwareHandler(servers, config.ZoneConfig{LocalZone: "a"}, tc.factory)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+
+			counts := map[string]int{}
+			for range 20 {
+				body, _ := sendWithCookie(zoneHandler, nil)
+				counts[body]++
+			}
+			if counts["remote"] != 0 {
+				t.Errorf("Request sent cross-zone with a healthy local zone: %v", counts)
+			}
+
+			local := zoneHandler.Handlers()[0]
+			for _, server := range local.Servers {
+				local.SetAlive(server, false)
+			}
+			body, _ := sendWithCookie(zoneHandler, nil)
+			if !strings.HasPrefix(body, "remote") {
+				t.Errorf("Request not spilled to the remote zone: got %s", body)
+			}
+		})
+	}
+}
+
+func TestZoneAwareStickySessionsDuringSpillover(t *testing.T) {
+	local1 := newNamedBackend(t, "local-1")
+	local2 := newNamedBackend(t, "local-2")
+	remote := newNamedBackend(t, "remote")
+
+	servers := []Server{
+		{ServerConfig: config.ServerConfig{Url: local1.URL, Zone: "a"}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: local2.URL, Zone: "a"}, IsAlive: true},
+		{ServerConfig: config.ServerConfig{Url: remote.URL, Zone: "b"}, IsAlive: true},
+	}
+	zoneHandler, err := NewZoneAwareHandler(servers, config.ZoneConfig{LocalZone: "a"}, func(servers []Server) (*Handler, error) {
+		handler := &NewRoundRobinHandler(servers).Handler
+		return handler, handler.EnableStickySessions(testStickyConfig)
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	// Half of the local zone is down, so 30% of the traffic spills over
+	local := zoneHandler.Handlers()[0]
+	local.SetAlive(local.Servers[1], false)
+
+	cookies := map[string]*http.Cookie{}
+	for len(cookies) < 2 {
+		body, cookie := sendWithCookie(zoneHandler, nil)
+		cookies[body] = cookie
+	}
+
+	for name, cookie := range cookies {
+		for range 100 {
+			if body, _ := sendWithCookie(zoneHandler, cookie); body != name {
+				t.Fatalf("Pinned client moved during spillover: got %s, want %s", body, name)
+			}
+		}
+	}
+}
+
+func TestZoneAwareErrors(t *testing.T) {
+	for _, zoneConfig := range []config.ZoneConfig{
+		{},
+		{LocalZone: "a", OverprovisioningFactor: 0.5},
+	} {
+		if _, err := NewZoneAwareHandler(newZonedServers(), zoneConfig, roundRobinFactory); err == nil {
+			t.Errorf("Expected error for zone config %+v", zoneConfig)
+		}
+	}
+}
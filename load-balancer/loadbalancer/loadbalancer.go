@@ -0,0 +1,280 @@
+// Package loadbalancer embeds the load balancer in another Go program. The
+// balancer is built from options instead of a config file:
+//
+//	lb, err := loadbalancer.New(
+//		loadbalancer.WithServers(config.ServerConfig{Url: "http://10.0.0.1:8080", Health: "/health"}),
+//		loadbalancer.WithStrategy("PowerOfTwoChoices"),
+//	)
+//	lb.Start()
+//	defer lb.Stop()
+//	http.ListenAndServe(":8080", lb)
+package loadbalancer
+
+import (
+	"context"
+	"emaiorov/load-balancer/config"
+	"emaiorov/load-balancer/handlers"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const defaultHealthCheckInterval = 5 * time.Second
+
+type settings struct {
+	config              config.Config
+	healthCheckInterval time.Duration
+	transport           http.RoundTripper
+	logger              *log.Logger
+}
+
+// Option configures a LoadBalancer. Options are applied in order, so a
+// later option overrides what an earlier WithConfig set.
+type Option func(*settings)
+
+// WithConfig takes every setting from a loaded config file.
+func WithConfig(appConfig *config.Config) Option {
+	return func(s *settings) {
+		s.config = *appConfig
+		if appConfig.App.HealthCheckSeconds > 0 {
+			s.healthCheckInterval = time.Duration(appConfig.App.HealthCheckSeconds) * time.Second
+		}
+	}
+}
+
+// WithServers replaces the backend servers.
+func WithServers(servers ...config.ServerConfig) Option {
+	return func(s *settings) {
+		s.config.Servers = servers
+	}
+}
+
+// WithStrategy selects a strategy registered with handlers.RegisterStrategy.
+func WithStrategy(name string) Option {
+	return func(s *settings) {
+		s.config.App.Handler = name
+	}
+}
+
+// WithHealthCheckInterval sets the time between health check rounds.
+func WithHealthCheckInterval(interval time.Duration) Option {
+	return func(s *settings) {
+		s.healthCheckInterval = interval
+	}
+}
+
+// WithTransport sets the transport used to reach the servers.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(s *settings) {
+		s.transport = transport
+	}
+}
+
+// WithLogger sets the logger for proxy errors and health check results.
+func WithLogger(logger *log.Logger) Option {
+	return func(s *settings) {
+		s.logger = logger
+	}
+}
+
+// LoadBalancer is an http.Handler spreading requests over the servers. It
+// proxies requests as soon as it is created; Start and Stop control the
+// background health checks.
+type LoadBalancer struct {
+	handler  http.Handler
+	handlers []*handlers.Handler
+	logger   *log.Logger
+
+	mu       sync.Mutex
+	checkers []*handlers.HealthChecker
+}
+
+func New(opts ...Option) (*LoadBalancer, error) {
+	s := settings{healthCheckInterval: defaultHealthCheckInterval}
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	if len(s.config.Servers) == 0 {
+		return nil, fmt.Errorf("no servers configured")
+	}
+	if s.healthCheckInterval <= 0 {
+		return nil, fmt.Errorf("health check interval must be positive, got %v", s.healthCheckInterval)
+	}
+
+	var servers []handlers.Server
+	for _, serverConfig := range s.config.Servers {
+		var counter handlers.Counter
+		counter.SetLenth(int(serverConfig.Weight))
+		servers = append(servers, handlers.Server{
+			ServerConfig: serverConfig,
+			IsAlive:      true,
+			Counter:      counter,
+		})
+	}
+
+	lb := &LoadBalancer{
+		logger: s.logger,
+	}
+
+	if s.config.Subset.Replicas > 0 {
+		total := len(servers)
+		var err error
+		servers, err = handlers.Subset(servers, s.config.Subset)
+		if err != nil {
+			return nil, err
+		}
+		lb.logf("Balancing over a subset of %d of %d servers", len(servers), total)
+	}
+
+	// One transport for all pools, so idle connections are shared
+	transport := s.transport
+	if transport == nil {
+		transport = handlers.NewTransport(s.config.Transport)
+	}
+
+	factory := func(servers []handlers.Server) (*handlers.Handler, error) {
+		handler, err := handlers.NewHandler(s.config.App.Handler, servers, &s.config)
+		if err != nil {
+			return nil, err
+		}
+		handler.Transport = transport
+		handler.Logger = s.logger
+		handler.HealthCheckInterval = s.healthCheckInterval
+		return handler, nil
+	}
+
+	if s.config.Zone.LocalZone != "" {
+		zoneHandler, err := handlers.NewZoneAwareHandler(servers, s.config.Zone, factory)
+		if err != nil {
+			return nil, err
+		}
+		lb.handler = zoneHandler
+		lb.handlers = zoneHandler.Handlers()
+	} else {
+		handler, err := factory(servers)
+		if err != nil {
+			return nil, err
+		}
+		lb.handler = handler
+		lb.handlers = []*handlers.Handler{handler}
+	}
+
+	return lb, nil
+}
+
+func (lb *LoadBalancer) logf(format string, v ...any) {
+	if lb.logger != nil {
+		lb.logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+func (lb *LoadBalancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	lb.handler.ServeHTTP(w, r)
+}
+
+// Start begins health checking the servers in the background. Calling it
+// again while running does nothing.
+func (lb *LoadBalancer) Start() {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	if lb.checkers != nil {
+		return
+	}
+
+	for _, handler := range lb.handlers {
+		// The health check config was validated by New
+		checker, err := handlers.StartHealthChecks(context.Background(), handler)
+		if err != nil {
+			lb.logf("Health checks not started: %v", err)
+			continue
+		}
+		lb.checkers = append(lb.checkers, checker)
+	}
+}
+
+// Stop ends the health checks, cancelling the ones in flight, and waits for
+// them to return. Ejected servers are returned to rotation and open circuits
+// turn half-open.
+func (lb *LoadBalancer) Stop() {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	for _, checker := range lb.checkers {
+		checker.Stop()
+	}
+	lb.checkers = nil
+	for _, handler := range lb.handlers {
+		handler.Stop()
+	}
+}
+
+// Stats returns the per-server statistics of the strategy, merged over the
+// zone pools, or nil when it does not report any.
+func (lb *LoadBalancer) Stats() any {
+	var pools []any
+	for _, handler := range lb.handlers {
+		if reporter, ok := handler.Strategy().(handlers.StatsReporter); ok {
+			pools = append(pools, reporter.Stats())
+		}
+	}
+	switch len(pools) {
+	case 0:
+		return nil
+	case 1:
+		return pools[0]
+	}
+
+	// Lists of servers are joined, other statistics are listed per pool
+	var servers []handlers.ServerStats
+	for _, stats := range pools {
+		poolServers, ok := stats.([]handlers.ServerStats)
+		if !ok {
+			return pools
+		}
+		servers = append(servers, poolServers...)
+	}
+	return servers
+}
+
+// CircuitStates returns the circuit breaker state of every server by url, or
+// nil when circuit breakers are off.
+func (lb *LoadBalancer) CircuitStates() map[string]string {
+	var states map[string]string
+	for _, handler := range lb.handlers {
+		for url, state := range handler.CircuitStates() {
+			if states == nil {
+				states = make(map[string]string)
+			}
+			states[url] = state
+		}
+	}
+	return states
+}
+
+// RetryStats returns the retries of all servers, or nil when retries are
+// off.
+func (lb *LoadBalancer) RetryStats() *handlers.RetryStats {
+	var total *handlers.RetryStats
+	for _, handler := range lb.handlers {
+		if stats := handler.RetryStats(); stats != nil {
+			if total == nil {
+				total = &handlers.RetryStats{}
+			}
+			total.Retries += stats.Retries
+			total.BudgetExhausted += stats.BudgetExhausted
+		}
+	}
+	return total
+}
+
+// Handlers returns the handlers owning the servers, one per zone pool when
+// zone aware routing is on.
+func (lb *LoadBalancer) Handlers() []*handlers.Handler {
+	return lb.handlers
+}
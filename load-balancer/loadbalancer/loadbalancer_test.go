@@ -0,0 +1,173 @@
+package loadbalancer
+
+import (
+	"bytes"
+	"emaiorov/load-balancer/config"
+	"emaiorov/load-balancer/handlers"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingTransport struct {
+	requests atomic.Int64
+}
+
+func (t *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.requests.Add(1)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func newBackend(t *testing.T, status int, body string) *httptest.Server {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func get(handler http.Handler) (int, string) {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	body, _ := io.ReadAll(w.Result().Body)
+	return w.Code, string(body)
+}
+
+func TestNewWithOptions(t *testing.T) {
+	backend := newBackend(t, http.StatusOK, "backend")
+	transport := &countingTransport{}
+
+	lb, err := New(
+		WithServers(config.ServerConfig{Url: backend.URL}),
+		WithStrategy("LeastResponseTime"),
+		WithTransport(transport),
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if code, body := get(lb); code != http.StatusOK || body != "backend" {
+		t.Errorf("Wrong response: got %d %s, want 200 backend", code, body)
+	}
+	if transport.requests.Load() != 1 {
+		t.Errorf("Wrong number of requests through the transport: got %d, want 1", transport.requests.Load())
+	}
+	if lb.Stats() == nil {
+		t.Errorf("No statistics from LeastResponseTime")
+	}
+}
+
+func TestStatsWithZones(t *testing.T) {
+	local := newBackend(t, http.StatusOK, "local")
+	remote := newBackend(t, http.StatusOK, "remote")
+	appConfig := &config.Config{Zone: config.ZoneConfig{LocalZone: "a"}}
+
+	lb, err := New(
+		WithConfig(appConfig),
+		WithServers(
+			config.ServerConfig{Url: local.URL, Zone: "a"},
+			config.ServerConfig{Url: remote.URL, Zone: "b"},
+		),
+		WithStrategy("LeastResponseTime"),
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	get(lb)
+
+	stats, ok := lb.Stats().([]handlers.ServerStats)
+	if !ok || len(stats) != 2 {
+		t.Fatalf("Wrong statistics with zones: got %#v, want both servers", lb.Stats())
+	}
+	for _, server := range stats {
+		expectedSamples := uint64(0)
+		if server.Url == local.URL {
+			expectedSamples = 1
+		}
+		if server.Samples != expectedSamples {
+			t.Errorf("Wrong samples of %s: got %d, want %d", server.Url, server.Samples, expectedSamples)
+		}
+	}
+}
+
+func TestWithConfigIsOverriddenByLaterOptions(t *testing.T) {
+	backend := newBackend(t, http.StatusOK, "backend")
+	appConfig := &config.Config{Servers: []config.ServerConfig{{Url: "http://config-server"}}}
+	appConfig.App.Handler = "Unknown"
+
+	if _, err := New(WithConfig(appConfig)); err == nil {
+		t.Errorf("Expected error for an unknown algorythm from the config")
+	}
+
+	lb, err := New(WithConfig(appConfig), WithStrategy("RoundRobin"), WithServers(config.ServerConfig{Url: backend.URL}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if _, body := get(lb); body != "backend" {
+		t.Errorf("Wrong backend: got %s, want backend", body)
+	}
+}
+
+func TestStartStopHealthChecks(t *testing.T) {
+	healthy := newBackend(t, http.StatusOK, "healthy")
+	unhealthy := newBackend(t, http.StatusServiceUnavailable, "unhealthy")
+	var logs bytes.Buffer
+
+	lb, err := New(
+		WithServers(
+			config.ServerConfig{Url: unhealthy.URL, Weight: 1},
+			config.ServerConfig{Url: healthy.URL, Weight: 1},
+		),
+		WithHealthCheckInterval(10*time.Millisecond),
+		WithLogger(log.New(&logs, "", 0)),
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	lb.Start()
+	lb.Start()
+	time.Sleep(50 * time.Millisecond)
+	lb.Stop()
+	lb.Stop()
+
+	for range 4 {
+		if _, body := get(lb); body != "healthy" {
+			t.Errorf("Request went to %s after health checks", body)
+		}
+	}
+	if !strings.Contains(logs.String(), "server "+unhealthy.URL+" is down") {
+		t.Errorf("Health check result not logged to the configured logger: %q", logs.String())
+	}
+
+	// No rounds run once stopped
+	logged := logs.Len()
+	time.Sleep(30 * time.Millisecond)
+	if logs.Len() != logged {
+		t.Errorf("Health checks kept running after Stop")
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts []Option
+	}{
+		{name: "CaseNoServers"},
+		{name: "CaseNoInterval", opts: []Option{WithServers(config.ServerConfig{Url: "http://a"}), WithHealthCheckInterval(0)}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := New(tc.opts...); err == nil {
+				t.Errorf("Expected error")
+			}
+		})
+	}
+}
@@ -1,47 +1,94 @@
 package main
 
 import (
+	"context"
 	"emaiorov/load-balancer/config"
-	"emaiorov/load-balancer/handlers"
+	"emaiorov/load-balancer/loadbalancer"
+	"encoding/json"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
 
-	var servers []handlers.Server
 	appConfig, err := config.Load("config.json")
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	for _, serverConfig := range appConfig.Servers {
-		var counter handlers.Counter
-		counter.SetLenth(int(serverConfig.Weight))
-		servers = append(servers, handlers.Server{
-			ServerConfig: serverConfig,
-			IsAlive:      true,
-			Counter:      counter,
-		})
+	lb, err := loadbalancer.New(loadbalancer.WithConfig(appConfig))
+	if err != nil {
+		log.Fatal(err)
 	}
+	lb.Start()
+	defer lb.Stop()
 
-	var handler *handlers.Handler
-	var lb handlers.LoadBalancer
-
-	switch appConfig.App.Handler {
-	case "LeastConnections":
-		lcHandler := handlers.NewLeastConnectionsHandler(servers)
-		handler = &lcHandler.Handler
-		lb = lcHandler
-	default:
-		rrHandler := handlers.NewRoundRobinHandler(servers)
-		handler = &rrHandler.Handler
-		lb = rrHandler
+	if appConfig.Admin.Port != "" {
+		go serveAdmin(appConfig.Admin.Port, lb)
 	}
 
-	go handlers.HealthCheck(handler, appConfig.App.HealthCheckSeconds)
+	server := &http.Server{Addr: ":" + appConfig.App.Port, Handler: lb}
+
+	// On SIGINT or SIGTERM finish the requests in flight and the health
+	// checks before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("shutdown error: %v", err)
+		}
+	}()
 
-	if err := http.ListenAndServe(":"+appConfig.App.Port, lb); err != nil {
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
 		log.Fatal(err)
 	}
+	<-shutdown
+}
+
+// serveAdmin exposes the statistics of the load balancer on its own port,
+// so no backend path is shadowed.
+func serveAdmin(port string, lb *loadbalancer.LoadBalancer) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		stats := lb.Stats()
+		if stats == nil {
+			http.Error(w, "algorythm does not report statistics", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	})
+
+	mux.HandleFunc("/circuits", func(w http.ResponseWriter, r *http.Request) {
+		states := lb.CircuitStates()
+		if states == nil {
+			http.Error(w, "circuit breakers are disabled", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(states)
+	})
+
+	mux.HandleFunc("/retries", func(w http.ResponseWriter, r *http.Request) {
+		stats := lb.RetryStats()
+		if stats == nil {
+			http.Error(w, "retries are disabled", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	})
+
+	if err := http.ListenAndServe(":"+port, mux); err != nil {
+		log.Printf("admin server error: %v", err)
+	}
 }